@@ -0,0 +1,62 @@
+package embeddings
+
+import (
+	"context"
+	"durkalive/app/config"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/samber/do"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Client calls an OpenAI-compatible /embeddings endpoint
+type Client struct {
+	client *openai.Client
+	model  string
+}
+
+func NewClient(di *do.Injector) (*Client, error) {
+	cfg := do.MustInvoke[*config.Config](di)
+
+	if cfg.OpenAI.Embedding == nil {
+		return nil, fmt.Errorf("embedding model is not configured")
+	}
+
+	clientConfig := openai.DefaultConfig(cfg.OpenAI.Embedding.Token)
+	clientConfig.BaseURL = cfg.OpenAI.Embedding.BaseURL
+	clientConfig.HTTPClient = &http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	return &Client{
+		client: openai.NewClientWithConfig(clientConfig),
+		model:  cfg.OpenAI.Embedding.Model,
+	}, nil
+}
+
+func (c *Client) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	resp, err := c.client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: texts,
+		Model: openai.EmbeddingModel(c.model),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings: %w", err)
+	}
+
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Data))
+	}
+
+	result := make([][]float32, len(texts))
+	for _, item := range resp.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d is out of range", item.Index)
+		}
+
+		result[item.Index] = item.Embedding
+	}
+
+	return result, nil
+}
@@ -0,0 +1,336 @@
+package eventsub
+
+import (
+	"bytes"
+	"context"
+	"durkalive/app/client/twitch"
+	"durkalive/app/config"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/nicklaw5/helix/v2"
+	"github.com/samber/do"
+)
+
+const (
+	welcomeTimeout = 10 * time.Second
+	maxBackoff     = 2 * time.Minute
+	// stableSession is how long a session must last for the backoff to reset
+	stableSession = time.Minute
+	// dedupSize is the number of recent message ids remembered to drop redelivered notifications
+	dedupSize = 128
+	// keepaliveSlack is added to the keepalive timeout announced by the server
+	keepaliveSlack = 5 * time.Second
+)
+
+const (
+	messageWelcome      = "session_welcome"
+	messageKeepalive    = "session_keepalive"
+	messageReconnect    = "session_reconnect"
+	messageNotification = "notification"
+	messageRevocation   = "revocation"
+)
+
+// Notification is an event of a subscription, Event is decoded by the receiver according to Type
+type Notification struct {
+	// Type is the subscription type, e.g. stream.online
+	Type  string
+	Event json.RawMessage
+}
+
+type Handler func(Notification)
+
+// twitchAPI is the part of the twitch client the subscriptions need
+type twitchAPI interface {
+	GetUserIDByUsername(username string) (string, error)
+	AccessToken() string
+}
+
+// Client keeps an EventSub websocket session with the stream and channel subscriptions of all the channels
+type Client struct {
+	cfg            *config.Config
+	twitchClient   twitchAPI
+	httpClient     *http.Client
+	keepaliveSlack time.Duration
+}
+
+type message struct {
+	Metadata struct {
+		MessageID        string `json:"message_id"`
+		MessageType      string `json:"message_type"`
+		SubscriptionType string `json:"subscription_type"`
+	} `json:"metadata"`
+	Payload struct {
+		Session      session `json:"session"`
+		Subscription struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"subscription"`
+		Event json.RawMessage `json:"event"`
+	} `json:"payload"`
+}
+
+type session struct {
+	ID                      string `json:"id"`
+	KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+	ReconnectURL            string `json:"reconnect_url"`
+}
+
+func (s *session) keepaliveTimeout() time.Duration {
+	return time.Duration(s.KeepaliveTimeoutSeconds) * time.Second
+}
+
+type subscriptionRequest struct {
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Condition map[string]string `json:"condition"`
+	Transport struct {
+		Method    string `json:"method"`
+		SessionID string `json:"session_id"`
+	} `json:"transport"`
+}
+
+func NewClient(di *do.Injector) (*Client, error) {
+	return &Client{
+		cfg:          do.MustInvoke[*config.Config](di),
+		twitchClient: do.MustInvoke[*twitch.Client](di),
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		keepaliveSlack: keepaliveSlack,
+	}, nil
+}
+
+// Run passes the notifications to the handler and reconnects until ctx is done
+func (c *Client) Run(ctx context.Context, handler Handler) {
+	backoff := time.Second
+
+	for {
+		startedAt := time.Now()
+
+		err := c.runSession(ctx, handler)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(startedAt) > stableSession {
+			backoff = time.Second
+		}
+
+		slog.Warn("EventSub session ended", "error", err, "retry_in", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (c *Client) runSession(ctx context.Context, handler Handler) error {
+	conn, sess, err := c.connect(ctx, c.cfg.Twitch.EventSub.URL)
+	if err != nil {
+		return err
+	}
+	// conn is replaced on reconnects
+	defer func() {
+		conn.CloseNow()
+	}()
+
+	if err = c.subscribe(ctx, sess.ID); err != nil {
+		return err
+	}
+
+	slog.Info("EventSub session started", "session_id", sess.ID)
+
+	seen := make(map[string]bool, dedupSize)
+	recent := make([]string, 0, dedupSize)
+
+	for {
+		msg, err := read(ctx, conn, sess.keepaliveTimeout()+c.keepaliveSlack)
+		if err != nil {
+			return err
+		}
+
+		if id := msg.Metadata.MessageID; id != "" {
+			if seen[id] {
+				continue
+			}
+
+			if len(recent) == dedupSize {
+				delete(seen, recent[0])
+				recent = recent[1:]
+			}
+			seen[id] = true
+			recent = append(recent, id)
+		}
+
+		switch msg.Metadata.MessageType {
+		case messageKeepalive:
+		case messageNotification:
+			handler(Notification{
+				Type:  msg.Metadata.SubscriptionType,
+				Event: msg.Payload.Event,
+			})
+		case messageReconnect:
+			// the subscriptions move to the new session, the old connection stays until the welcome
+			newConn, newSess, err := c.connect(ctx, msg.Payload.Session.ReconnectURL)
+			if err != nil {
+				return fmt.Errorf("failed to reconnect: %w", err)
+			}
+
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+			conn, sess = newConn, newSess
+
+			slog.Info("EventSub session reconnected", "session_id", sess.ID)
+		case messageRevocation:
+			slog.Warn("EventSub subscription revoked",
+				"type", msg.Payload.Subscription.Type,
+				"status", msg.Payload.Subscription.Status)
+		default:
+			slog.Debug("Unknown EventSub message", "type", msg.Metadata.MessageType)
+		}
+	}
+}
+
+// connect opens a websocket and waits for the welcome message
+func (c *Client) connect(ctx context.Context, url string) (*websocket.Conn, *session, error) {
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to eventsub: %w", err)
+	}
+	conn.SetReadLimit(1 << 20)
+
+	msg, err := read(ctx, conn, welcomeTimeout)
+	if err != nil {
+		conn.CloseNow()
+		return nil, nil, fmt.Errorf("failed to get welcome message: %w", err)
+	}
+
+	if msg.Metadata.MessageType != messageWelcome {
+		conn.CloseNow()
+		return nil, nil, fmt.Errorf("unexpected first message %q", msg.Metadata.MessageType)
+	}
+
+	return conn, &msg.Payload.Session, nil
+}
+
+func read(ctx context.Context, conn *websocket.Conn, timeout time.Duration) (*message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, data, err := conn.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	var msg message
+	if err = json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("failed to decode message: %w", err)
+	}
+
+	return &msg, nil
+}
+
+// subscribe creates the subscriptions of all the channels, the ones the bot has no access to are skipped.
+// Subscriptions, cheers and redemptions need the token of the broadcaster, they are only created on the bot's own channel.
+func (c *Client) subscribe(ctx context.Context, sessionID string) error {
+	botID, err := c.twitchClient.GetUserIDByUsername(c.cfg.Twitch.Username)
+	if err != nil {
+		return fmt.Errorf("failed to get bot id: %w", err)
+	}
+
+	for _, channel := range c.cfg.Twitch.Channels {
+		broadcasterID, err := c.twitchClient.GetUserIDByUsername(channel.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get broadcaster id of %s: %w", channel.Name, err)
+		}
+
+		broadcaster := map[string]string{"broadcaster_user_id": broadcasterID}
+
+		subscriptions := []subscriptionRequest{
+			{Type: helix.EventSubTypeStreamOnline, Version: "1", Condition: broadcaster},
+			{Type: helix.EventSubTypeStreamOffline, Version: "1", Condition: broadcaster},
+			{Type: helix.EventSubTypeChannelUpdate, Version: "2", Condition: broadcaster},
+			{Type: helix.EventSubTypeChannelRaid, Version: "1", Condition: map[string]string{
+				"to_broadcaster_user_id": broadcasterID,
+			}},
+			// follows need the bot to be a moderator or the broadcaster
+			{Type: helix.EventSubTypeChannelFollow, Version: "2", Condition: map[string]string{
+				"broadcaster_user_id": broadcasterID,
+				"moderator_user_id":   botID,
+			}},
+		}
+
+		// the topics below need the token of the broadcaster, moderator access is not enough
+		broadcasterOnly := []subscriptionRequest{
+			{Type: helix.EventSubTypeChannelSubscription, Version: "1", Condition: broadcaster},
+			{Type: helix.EventSubTypeChannelSubscriptionMessage, Version: "1", Condition: broadcaster},
+			{Type: helix.EventSubTypeChannelCheer, Version: "1", Condition: broadcaster},
+			{Type: helix.EventSubTypeChannelPointsCustomRewardRedemptionAdd, Version: "1", Condition: broadcaster},
+		}
+
+		if botID == broadcasterID {
+			subscriptions = append(subscriptions, broadcasterOnly...)
+		} else {
+			skipped := make([]string, 0, len(broadcasterOnly))
+			for _, subscription := range broadcasterOnly {
+				skipped = append(skipped, subscription.Type)
+			}
+
+			slog.Info("Skipping events that need the broadcaster token",
+				"channel", channel.Name,
+				"types", skipped)
+		}
+
+		for _, subscription := range subscriptions {
+			subscription.Transport.Method = "websocket"
+			subscription.Transport.SessionID = sessionID
+
+			if err = c.createSubscription(ctx, subscription); err != nil {
+				slog.Warn("Failed to subscribe to event",
+					"channel", channel.Name,
+					"type", subscription.Type,
+					"error", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) createSubscription(ctx context.Context, subscription subscriptionRequest) error {
+	body, err := json.Marshal(subscription)
+	if err != nil {
+		return fmt.Errorf("failed to encode subscription: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.Twitch.EventSub.SubscriptionsURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Client-Id", c.cfg.Twitch.ClientID)
+	req.Header.Set("Authorization", "Bearer "+c.twitchClient.AccessToken())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, respBody)
+	}
+
+	return nil
+}
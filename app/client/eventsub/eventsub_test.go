@@ -0,0 +1,273 @@
+package eventsub
+
+import (
+	"context"
+	"durkalive/app/config"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+func newTestClient(server *fakeServer) *Client {
+	cfg := &config.Config{}
+	cfg.Twitch.ClientID = "client"
+	cfg.Twitch.Username = "bot"
+	cfg.Twitch.Channels = []config.Channel{{Name: "channel"}}
+	cfg.Twitch.EventSub.URL = server.wsURL()
+	cfg.Twitch.EventSub.SubscriptionsURL = server.subscriptionsURL()
+
+	return &Client{
+		cfg:          cfg,
+		twitchClient: fakeToken{},
+		httpClient:   &http.Client{Timeout: time.Second},
+		// the keepalive timeouts of the tests are exactly the announced ones
+		keepaliveSlack: 0,
+	}
+}
+
+// run starts the client and returns the received notifications, the client stops at the end of the test
+func run(t *testing.T, client *Client) <-chan Notification {
+	ctx, cancel := context.WithCancel(context.Background())
+	notifications := make(chan Notification, 16)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		client.Run(ctx, func(notification Notification) {
+			notifications <- notification
+		})
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("Run did not stop after the context was canceled")
+		}
+	})
+
+	return notifications
+}
+
+func expectNotification(t *testing.T, notifications <-chan Notification, subscriptionType string) Notification {
+	t.Helper()
+
+	select {
+	case notification := <-notifications:
+		if notification.Type != subscriptionType {
+			t.Fatalf("notification type = %q, want %q", notification.Type, subscriptionType)
+		}
+		return notification
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no %s notification", subscriptionType)
+		return Notification{}
+	}
+}
+
+// number of topics per channel, on its own channel the bot also subscribes to subscriptions, cheers and redemptions
+const (
+	moderatorTopics   = 5
+	broadcasterTopics = 9
+)
+
+// waitSubscriptions waits for the client to subscribe to the given number of topics of the session
+func waitSubscriptions(t *testing.T, server *fakeServer, sessionID string, topics int) []subscriptionRequest {
+	t.Helper()
+
+	var result []subscriptionRequest
+	deadline := time.After(2 * time.Second)
+	for len(result) < topics {
+		select {
+		case request := <-server.subscriptions:
+			result = append(result, request)
+		case <-deadline:
+			t.Fatalf("got %d subscriptions, want %d", len(result), topics)
+		}
+	}
+
+	for _, request := range result {
+		if request.Transport.Method != "websocket" || request.Transport.SessionID != sessionID {
+			t.Errorf("subscription %s transport = %+v, want websocket session %s", request.Type, request.Transport, sessionID)
+		}
+	}
+
+	return result
+}
+
+func TestRunSubscribesAndDispatches(t *testing.T) {
+	server := newFakeServer(t, 10)
+	notifications := run(t, newTestClient(server))
+
+	session := server.session(2 * time.Second)
+	subscriptions := waitSubscriptions(t, server, session.id, moderatorTopics)
+
+	conditions := make(map[string]map[string]string)
+	for _, request := range subscriptions {
+		conditions[request.Type] = request.Condition
+	}
+	if got := conditions[helix.EventSubTypeStreamOnline]["broadcaster_user_id"]; got != "id-channel" {
+		t.Errorf("stream.online broadcaster = %q, want id-channel", got)
+	}
+	if got := conditions[helix.EventSubTypeChannelRaid]["to_broadcaster_user_id"]; got != "id-channel" {
+		t.Errorf("channel.raid to_broadcaster = %q, want id-channel", got)
+	}
+	if got := conditions[helix.EventSubTypeChannelFollow]["moderator_user_id"]; got != "id-bot" {
+		t.Errorf("channel.follow moderator = %q, want id-bot", got)
+	}
+
+	session.keepalive("keepalive-1")
+	session.notification("notification-1", helix.EventSubTypeStreamOnline, map[string]string{
+		"broadcaster_user_login": "channel",
+		"type":                   "live",
+	})
+
+	notification := expectNotification(t, notifications, helix.EventSubTypeStreamOnline)
+
+	var event helix.EventSubStreamOnlineEvent
+	if err := json.Unmarshal(notification.Event, &event); err != nil {
+		t.Fatalf("failed to decode event: %v", err)
+	}
+	if event.BroadcasterUserLogin != "channel" || event.Type != "live" {
+		t.Errorf("event = %+v", event)
+	}
+}
+
+func TestRunSubscribesBroadcasterTopicsOnOwnChannel(t *testing.T) {
+	server := newFakeServer(t, 10)
+	client := newTestClient(server)
+	client.cfg.Twitch.Username = "channel"
+	run(t, client)
+
+	session := server.session(2 * time.Second)
+	subscriptions := waitSubscriptions(t, server, session.id, broadcasterTopics)
+
+	types := make(map[string]bool)
+	for _, request := range subscriptions {
+		types[request.Type] = true
+	}
+	for _, subscriptionType := range []string{
+		helix.EventSubTypeChannelSubscription,
+		helix.EventSubTypeChannelCheer,
+		helix.EventSubTypeChannelPointsCustomRewardRedemptionAdd,
+	} {
+		if !types[subscriptionType] {
+			t.Errorf("no %s subscription", subscriptionType)
+		}
+	}
+}
+
+func TestRunDropsRedeliveredNotifications(t *testing.T) {
+	server := newFakeServer(t, 10)
+	notifications := run(t, newTestClient(server))
+
+	session := server.session(2 * time.Second)
+	waitSubscriptions(t, server, session.id, moderatorTopics)
+
+	event := map[string]string{"broadcaster_user_login": "channel"}
+	session.notification("notification-1", helix.EventSubTypeChannelFollow, event)
+	session.notification("notification-1", helix.EventSubTypeChannelFollow, event)
+	session.notification("notification-2", helix.EventSubTypeChannelCheer, event)
+
+	expectNotification(t, notifications, helix.EventSubTypeChannelFollow)
+	expectNotification(t, notifications, helix.EventSubTypeChannelCheer)
+}
+
+func TestRunIgnoresRevocation(t *testing.T) {
+	server := newFakeServer(t, 10)
+	notifications := run(t, newTestClient(server))
+
+	session := server.session(2 * time.Second)
+	waitSubscriptions(t, server, session.id, moderatorTopics)
+
+	session.revocation("revocation-1", helix.EventSubTypeChannelFollow)
+	session.notification("notification-1", helix.EventSubTypeStreamOffline, map[string]string{
+		"broadcaster_user_login": "channel",
+	})
+
+	// the revocation is not dispatched and the session goes on
+	expectNotification(t, notifications, helix.EventSubTypeStreamOffline)
+
+	if session.isClosed() {
+		t.Errorf("session closed after a revocation")
+	}
+	server.noSession(100 * time.Millisecond)
+}
+
+func TestRunRequiresWelcome(t *testing.T) {
+	server := newFakeServer(t, 10)
+	server.skipWelcome.Store(true)
+	notifications := run(t, newTestClient(server))
+
+	first := server.session(2 * time.Second)
+	first.waitClosed(2 * time.Second)
+
+	if subscriptions := server.drainSubscriptions(); len(subscriptions) != 0 {
+		t.Fatalf("subscribed without a welcome: %+v", subscriptions)
+	}
+
+	// the client retries after the backoff and gets a welcome this time
+	second := server.session(3 * time.Second)
+	waitSubscriptions(t, server, second.id, moderatorTopics)
+
+	second.notification("notification-1", helix.EventSubTypeStreamOnline, map[string]string{})
+	expectNotification(t, notifications, helix.EventSubTypeStreamOnline)
+}
+
+func TestRunKeepaliveTimeout(t *testing.T) {
+	server := newFakeServer(t, 1)
+	run(t, newTestClient(server))
+
+	first := server.session(2 * time.Second)
+	waitSubscriptions(t, server, first.id, moderatorTopics)
+
+	// keepalives within the announced timeout keep the session
+	for i := range 4 {
+		time.Sleep(400 * time.Millisecond)
+		first.keepalive(fmt.Sprintf("keepalive-%d", i))
+	}
+	if first.isClosed() {
+		t.Fatalf("session closed while receiving keepalives")
+	}
+
+	// silence longer than the timeout ends the session and the client starts a new one
+	first.waitClosed(2 * time.Second)
+
+	second := server.session(3 * time.Second)
+	waitSubscriptions(t, server, second.id, moderatorTopics)
+}
+
+func TestRunReconnect(t *testing.T) {
+	server := newFakeServer(t, 10)
+	notifications := run(t, newTestClient(server))
+
+	first := server.session(2 * time.Second)
+	waitSubscriptions(t, server, first.id, moderatorTopics)
+
+	first.notification("notification-1", helix.EventSubTypeStreamOnline, map[string]string{})
+	expectNotification(t, notifications, helix.EventSubTypeStreamOnline)
+
+	first.reconnect("reconnect-1", server.wsURL()+"?reconnect=true")
+
+	second := server.session(2 * time.Second)
+	if second.id == first.id {
+		t.Fatalf("reconnected to the same session")
+	}
+
+	// the old connection is closed only after the welcome of the new one
+	first.waitClosed(2 * time.Second)
+
+	// the subscriptions move to the new session on their own
+	if subscriptions := server.drainSubscriptions(); len(subscriptions) != 0 {
+		t.Errorf("subscribed again after a reconnect: %+v", subscriptions)
+	}
+
+	// the message ids stay deduplicated across the handover
+	second.notification("notification-1", helix.EventSubTypeStreamOnline, map[string]string{})
+	second.notification("notification-2", helix.EventSubTypeStreamOffline, map[string]string{})
+	expectNotification(t, notifications, helix.EventSubTypeStreamOffline)
+}
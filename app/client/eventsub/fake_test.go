@@ -0,0 +1,270 @@
+package eventsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// fakeServer is a local EventSub server speaking the message format of the twitch cli mock server
+// (twitch event websocket start-server): /ws for the websocket sessions and /eventsub/subscriptions
+type fakeServer struct {
+	t      *testing.T
+	server *httptest.Server
+	// keepalive is the keepalive_timeout_seconds announced in the welcome messages
+	keepalive int
+	// skipWelcome makes the next session start with a keepalive instead of the welcome message
+	skipWelcome atomic.Bool
+
+	sessions      chan *fakeSession
+	subscriptions chan subscriptionRequest
+	lastID        atomic.Int64
+}
+
+type fakeSession struct {
+	t    *testing.T
+	id   string
+	conn *websocket.Conn
+	// closed is done when the client closes the connection
+	closed context.Context
+}
+
+type fakeToken struct{}
+
+func (fakeToken) GetUserIDByUsername(username string) (string, error) {
+	return "id-" + username, nil
+}
+
+func (fakeToken) AccessToken() string {
+	return "token"
+}
+
+func newFakeServer(t *testing.T, keepalive int) *fakeServer {
+	s := &fakeServer{
+		t:             t,
+		keepalive:     keepalive,
+		sessions:      make(chan *fakeSession, 16),
+		subscriptions: make(chan subscriptionRequest, 256),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.handleWebsocket)
+	mux.HandleFunc("POST /eventsub/subscriptions", s.handleSubscription)
+
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+
+	return s
+}
+
+func (s *fakeServer) wsURL() string {
+	return "ws" + strings.TrimPrefix(s.server.URL, "http") + "/ws"
+}
+
+func (s *fakeServer) subscriptionsURL() string {
+	return s.server.URL + "/eventsub/subscriptions"
+}
+
+func (s *fakeServer) nextID() string {
+	return fmt.Sprintf("%08d-0000-0000-0000-000000000000", s.lastID.Add(1))
+}
+
+func (s *fakeServer) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		s.t.Errorf("failed to accept websocket: %v", err)
+		return
+	}
+
+	session := &fakeSession{
+		t:    s.t,
+		id:   s.nextID(),
+		conn: conn,
+	}
+	// the client never writes, reading only handles the close handshake
+	session.closed = conn.CloseRead(context.Background())
+
+	if s.skipWelcome.CompareAndSwap(true, false) {
+		session.send(s.nextID(), messageKeepalive, map[string]any{})
+	} else {
+		session.send(s.nextID(), messageWelcome, map[string]any{
+			"session": map[string]any{
+				"id":                        session.id,
+				"status":                    "connected",
+				"keepalive_timeout_seconds": s.keepalive,
+				"reconnect_url":             nil,
+				"connected_at":              time.Now().UTC().Format(time.RFC3339Nano),
+			},
+		})
+	}
+
+	s.sessions <- session
+
+	<-session.closed.Done()
+}
+
+func (s *fakeServer) handleSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Client-Id") == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var request subscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.subscriptions <- request
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"data": []map[string]any{{
+			"id":         s.nextID(),
+			"status":     "enabled",
+			"type":       request.Type,
+			"version":    request.Version,
+			"condition":  request.Condition,
+			"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+			"transport":  request.Transport,
+			"cost":       0,
+		}},
+		"total":          1,
+		"total_cost":     0,
+		"max_total_cost": 10,
+	})
+}
+
+// session waits for the next websocket session opened by the client
+func (s *fakeServer) session(timeout time.Duration) *fakeSession {
+	s.t.Helper()
+
+	select {
+	case session := <-s.sessions:
+		return session
+	case <-time.After(timeout):
+		s.t.Fatalf("no session within %s", timeout)
+		return nil
+	}
+}
+
+// noSession fails if the client opens a session within d
+func (s *fakeServer) noSession(d time.Duration) {
+	s.t.Helper()
+
+	select {
+	case session := <-s.sessions:
+		s.t.Fatalf("unexpected session %s", session.id)
+	case <-time.After(d):
+	}
+}
+
+// drainSubscriptions returns the subscriptions created so far
+func (s *fakeServer) drainSubscriptions() []subscriptionRequest {
+	var result []subscriptionRequest
+	for {
+		select {
+		case request := <-s.subscriptions:
+			result = append(result, request)
+		default:
+			return result
+		}
+	}
+}
+
+func (s *fakeSession) send(messageID, messageType string, payload any, metadata ...string) {
+	s.t.Helper()
+
+	meta := map[string]any{
+		"message_id":        messageID,
+		"message_type":      messageType,
+		"message_timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	for i := 0; i+1 < len(metadata); i += 2 {
+		meta[metadata[i]] = metadata[i+1]
+	}
+
+	data, err := json.Marshal(map[string]any{
+		"metadata": meta,
+		"payload":  payload,
+	})
+	if err != nil {
+		s.t.Fatalf("failed to encode message: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err = s.conn.Write(ctx, websocket.MessageText, data); err != nil {
+		s.t.Errorf("failed to write %s message: %v", messageType, err)
+	}
+}
+
+func (s *fakeSession) keepalive(messageID string) {
+	s.send(messageID, messageKeepalive, map[string]any{})
+}
+
+func (s *fakeSession) notification(messageID, subscriptionType string, event any) {
+	s.send(messageID, messageNotification, map[string]any{
+		"subscription": map[string]any{
+			"id":        "sub-" + subscriptionType,
+			"status":    "enabled",
+			"type":      subscriptionType,
+			"version":   "1",
+			"condition": map[string]string{"broadcaster_user_id": "id-channel"},
+			"transport": map[string]string{"method": "websocket", "session_id": s.id},
+			"cost":      0,
+		},
+		"event": event,
+	}, "subscription_type", subscriptionType, "subscription_version", "1")
+}
+
+func (s *fakeSession) revocation(messageID, subscriptionType string) {
+	s.send(messageID, messageRevocation, map[string]any{
+		"subscription": map[string]any{
+			"id":        "sub-" + subscriptionType,
+			"status":    "authorization_revoked",
+			"type":      subscriptionType,
+			"version":   "1",
+			"condition": map[string]string{"broadcaster_user_id": "id-channel"},
+			"transport": map[string]string{"method": "websocket", "session_id": s.id},
+			"cost":      0,
+		},
+	}, "subscription_type", subscriptionType, "subscription_version", "1")
+}
+
+func (s *fakeSession) reconnect(messageID, url string) {
+	s.send(messageID, messageReconnect, map[string]any{
+		"session": map[string]any{
+			"id":                        s.id,
+			"status":                    "reconnecting",
+			"keepalive_timeout_seconds": nil,
+			"reconnect_url":             url,
+			"connected_at":              time.Now().UTC().Format(time.RFC3339Nano),
+		},
+	})
+}
+
+// waitClosed fails if the client keeps the connection open longer than timeout
+func (s *fakeSession) waitClosed(timeout time.Duration) {
+	s.t.Helper()
+
+	select {
+	case <-s.closed.Done():
+	case <-time.After(timeout):
+		s.t.Fatalf("session %s is still open after %s", s.id, timeout)
+	}
+}
+
+func (s *fakeSession) isClosed() bool {
+	return s.closed.Err() != nil
+}
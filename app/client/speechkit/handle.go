@@ -2,47 +2,48 @@ package speechkit
 
 import (
 	"context"
+	"durkalive/app/client/stt"
 	"fmt"
 	"strings"
 
-	"github.com/yandex-cloud/go-genproto/yandex/cloud/ai/stt/v3"
+	sttv3 "github.com/yandex-cloud/go-genproto/yandex/cloud/ai/stt/v3"
 )
 
 type Handle struct {
-	client stt.Recognizer_RecognizeStreamingClient
+	client sttv3.Recognizer_RecognizeStreamingClient
 	cancel context.CancelFunc
 }
 
 func (h *Handle) Send(content []byte) error {
-	var req stt.StreamingRequest
-	req.SetChunk(&stt.AudioChunk{
+	var req sttv3.StreamingRequest
+	req.SetChunk(&sttv3.AudioChunk{
 		Data: content,
 	})
 
 	return h.client.Send(&req)
 }
 
-func (h *Handle) SendConfig() error {
-	var audioFormatOpts stt.AudioFormatOptions
-	audioFormatOpts.SetRawAudio(&stt.RawAudio{
-		AudioEncoding:     stt.RawAudio_LINEAR16_PCM,
-		SampleRateHertz:   16000,
+func (h *Handle) sendConfig() error {
+	var audioFormatOpts sttv3.AudioFormatOptions
+	audioFormatOpts.SetRawAudio(&sttv3.RawAudio{
+		AudioEncoding:     sttv3.RawAudio_LINEAR16_PCM,
+		SampleRateHertz:   stt.SampleRate,
 		AudioChannelCount: 1,
 	})
 
-	var eouClassifier stt.EouClassifierOptions
-	eouClassifier.SetDefaultClassifier(&stt.DefaultEouClassifier{
-		Type:                       stt.DefaultEouClassifier_HIGH,
+	var eouClassifier sttv3.EouClassifierOptions
+	eouClassifier.SetDefaultClassifier(&sttv3.DefaultEouClassifier{
+		Type:                       sttv3.DefaultEouClassifier_HIGH,
 		MaxPauseBetweenWordsHintMs: 500,
 	})
 
-	var req stt.StreamingRequest
-	req.SetSessionOptions(&stt.StreamingOptions{
-		RecognitionModel: &stt.RecognitionModelOptions{
+	var req sttv3.StreamingRequest
+	req.SetSessionOptions(&sttv3.StreamingOptions{
+		RecognitionModel: &sttv3.RecognitionModelOptions{
 			Model:       "general",
 			AudioFormat: &audioFormatOpts,
-			LanguageRestriction: &stt.LanguageRestrictionOptions{
-				RestrictionType: stt.LanguageRestrictionOptions_WHITELIST,
+			LanguageRestriction: &sttv3.LanguageRestrictionOptions{
+				RestrictionType: sttv3.LanguageRestrictionOptions_WHITELIST,
 				LanguageCode:    []string{"ru-RU"},
 			},
 		},
@@ -52,30 +53,48 @@ func (h *Handle) SendConfig() error {
 	return h.client.Send(&req)
 }
 
-func (h *Handle) Recv() ([]string, error) {
+func (h *Handle) Recv() ([]stt.Phrase, error) {
 	res, err := h.client.Recv()
 	if err != nil {
 		return nil, fmt.Errorf("failed to receive stt: %w", err)
 	}
 
-	finalEvent := res.GetFinal()
-	if finalEvent == nil {
+	var (
+		update *sttv3.AlternativeUpdate
+		final  bool
+	)
+
+	if update = res.GetFinal(); update != nil {
+		final = true
+	} else if update = res.GetPartial(); update == nil {
 		return nil, nil
 	}
 
-	result := make([]string, 0, len(finalEvent.Alternatives))
-	for _, alt := range finalEvent.Alternatives {
+	result := make([]stt.Phrase, 0, len(update.Alternatives))
+	for _, alt := range update.Alternatives {
 		text := strings.TrimSpace(alt.Text)
 		if text == "" {
 			continue
 		}
 
-		result = append(result, text)
+		result = append(result, stt.Phrase{
+			Text:  text,
+			Final: final,
+		})
 	}
 
 	return result, nil
 }
 
+// Flush does nothing, the end of utterance classifier of the server finishes phrases by itself
+func (h *Handle) Flush() error {
+	return nil
+}
+
+func (h *Handle) CloseSend() error {
+	return h.client.CloseSend()
+}
+
 func (h *Handle) Close() error {
 	h.cancel()
 	return nil
@@ -2,6 +2,7 @@ package speechkit
 
 import (
 	"context"
+	"durkalive/app/client/stt"
 	"durkalive/app/config"
 	"encoding/json"
 	"fmt"
@@ -49,7 +50,7 @@ func NewClient(di *do.Injector) (*YandexSpeechKit, error) {
 	}, nil
 }
 
-func (y *YandexSpeechKit) Start(ctx context.Context) (*Handle, error) {
+func (y *YandexSpeechKit) Start(ctx context.Context) (stt.Session, error) {
 	ctx, cancel := context.WithCancel(ctx)
 
 	client, err := y.sdk.AI().STTV3().Recognizer().RecognizeStreaming(ctx)
@@ -58,8 +59,15 @@ func (y *YandexSpeechKit) Start(ctx context.Context) (*Handle, error) {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
 
-	return &Handle{
+	handle := &Handle{
 		client: client,
 		cancel: cancel,
-	}, nil
+	}
+
+	if err = handle.sendConfig(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to send session config: %w", err)
+	}
+
+	return handle, nil
 }
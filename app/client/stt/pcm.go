@@ -0,0 +1,31 @@
+package stt
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// RMS returns the root mean square amplitude of 16-bit PCM samples
+func RMS(pcm []byte) float64 {
+	samples := len(pcm) / BytesPerSample
+	if samples == 0 {
+		return 0
+	}
+
+	var sum float64
+	for i := 0; i < samples; i++ {
+		sample := float64(int16(binary.LittleEndian.Uint16(pcm[i*BytesPerSample:])))
+		sum += sample * sample
+	}
+
+	return math.Sqrt(sum / float64(samples))
+}
+
+func DurationToBytes(d time.Duration) int {
+	return int(d.Seconds()*SampleRate) * BytesPerSample
+}
+
+func BytesToDuration(n int) time.Duration {
+	return time.Duration(n/BytesPerSample) * time.Second / SampleRate
+}
@@ -0,0 +1,35 @@
+package stt
+
+import (
+	"context"
+)
+
+// Audio format every provider expects: raw signed 16-bit little-endian mono PCM
+const (
+	SampleRate     = 16000
+	BytesPerSample = 2
+)
+
+type Phrase struct {
+	Text string
+	// Final is false for partial results that may still change
+	Final bool
+}
+
+// Provider turns a stream of PCM audio into phrases
+type Provider interface {
+	Start(ctx context.Context) (Session, error)
+}
+
+// Session is a single recognition stream. Send and Recv may be called from different goroutines.
+type Session interface {
+	// Send pushes the next piece of PCM audio
+	Send(pcm []byte) error
+	// Recv blocks until new phrases are recognized, io.EOF means the session has ended
+	Recv() ([]Phrase, error)
+	// Flush tells the provider the speech has paused, the audio stops until the next speech
+	Flush() error
+	// CloseSend marks the end of the audio, Recv returns the phrases of the rest of it and then io.EOF
+	CloseSend() error
+	Close() error
+}
@@ -3,15 +3,25 @@ package twitch
 import (
 	"context"
 	"durkalive/app/config"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/nicklaw5/helix/v2"
 	"github.com/samber/do"
 )
 
+var (
+	// ErrStreamOffline is returned for channels that are not live
+	ErrStreamOffline = errors.New("stream is offline")
+	// ErrInvalidReplyParent is returned when twitch refuses the reply because of its parent message,
+	// e.g. the message is deleted, nothing is posted then
+	ErrInvalidReplyParent = errors.New("invalid reply parent message")
+)
+
 type Client struct {
 	cfg        *config.Config
 	userClient *helix.Client
@@ -71,6 +81,11 @@ func (c *Client) GetUserIDByUsername(username string) (string, error) {
 }
 
 func (c *Client) SendMessage(channel, text string) error {
+	return c.SendReply(channel, text, "")
+}
+
+// SendReply posts the message in the thread of the parent message, a top level one if parentID is empty
+func (c *Client) SendReply(channel, text, parentID string) error {
 	broadcasterID, err := c.GetUserIDByUsername(channel)
 	if err != nil {
 		return fmt.Errorf("failed to get broadcaster id: %v", err)
@@ -82,45 +97,86 @@ func (c *Client) SendMessage(channel, text string) error {
 	}
 
 	resp, err := c.userClient.SendChatMessage(&helix.SendChatMessageParams{
-		BroadcasterID: broadcasterID,
-		SenderID:      senderID,
-		Message:       text,
+		BroadcasterID:        broadcasterID,
+		SenderID:             senderID,
+		Message:              text,
+		ReplyParentMessageID: parentID,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to send message: %v", err)
 	}
+	if resp.StatusCode == http.StatusBadRequest && parentID != "" && isInvalidReplyParent(resp.ErrorMessage) {
+		return fmt.Errorf("%w: %s", ErrInvalidReplyParent, resp.ErrorMessage)
+	}
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("failed to send message: status %d: %s", resp.StatusCode, resp.ErrorMessage)
 	}
+	if len(resp.Data.Messages) > 0 && !resp.Data.Messages[0].IsSent {
+		reason := resp.Data.Messages[0].DropReasons.Data
+		return fmt.Errorf("message dropped: %s: %s", reason.Code, reason.Message)
+	}
 
 	return nil
 }
 
-func (c *Client) GetStreamStartedAt(username string) (time.Time, error) {
-	userID, err := c.GetUserIDByUsername(username)
-	if err != nil {
-		return time.Time{}, fmt.Errorf("failed to get user id: %v", err)
-	}
+// isInvalidReplyParent reports whether a bad request error is about the parent message,
+// twitch answers "The ID in reply_parent_message_id is not valid." then
+func isInvalidReplyParent(message string) bool {
+	return strings.Contains(strings.ToLower(message), "reply_parent_message_id")
+}
 
+// GetStream returns the live stream of the user, ErrStreamOffline if there is none
+func (c *Client) GetStream(username string) (*helix.Stream, error) {
 	resp, err := c.userClient.GetStreams(&helix.StreamsParams{
-		UserIDs: []string{userID},
+		UserLogins: []string{username},
 	})
 	if err != nil {
-		return time.Time{}, fmt.Errorf("failed to get stream info: %v", err)
+		return nil, fmt.Errorf("failed to get stream info: %v", err)
 	}
 	if resp.StatusCode != 200 {
-		return time.Time{}, fmt.Errorf("failed to get stream info: status %d: %s", resp.StatusCode, resp.ErrorMessage)
+		return nil, fmt.Errorf("failed to get stream info: status %d: %s", resp.StatusCode, resp.ErrorMessage)
 	}
 
 	if len(resp.Data.Streams) == 0 {
-		return time.Time{}, fmt.Errorf("stream is not live")
+		return nil, ErrStreamOffline
 	}
 
-	stream := resp.Data.Streams[0]
+	return &resp.Data.Streams[0], nil
+}
+
+func (c *Client) GetStreamStartedAt(username string) (time.Time, error) {
+	stream, err := c.GetStream(username)
+	if err != nil {
+		return time.Time{}, err
+	}
 
 	return stream.StartedAt, nil
 }
 
+// GetVideoCreatedAt returns the time a VOD was created, which is the start of its broadcast
+func (c *Client) GetVideoCreatedAt(videoID string) (time.Time, error) {
+	resp, err := c.userClient.GetVideos(&helix.VideosParams{
+		IDs: []string{videoID},
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get video info: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		return time.Time{}, fmt.Errorf("failed to get video info: status %d: %s", resp.StatusCode, resp.ErrorMessage)
+	}
+
+	if len(resp.Data.Videos) == 0 {
+		return time.Time{}, fmt.Errorf("video not found")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, resp.Data.Videos[0].CreatedAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse video creation time: %v", err)
+	}
+
+	return createdAt, nil
+}
+
 func (c *Client) refreshToken() {
 	slog.Debug("Refreshing twitch access token",
 		slog.String("username", c.cfg.Twitch.Username),
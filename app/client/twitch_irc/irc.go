@@ -14,7 +14,8 @@ import (
 	"github.com/samber/do"
 )
 
-type MessageHandler func(channel, username, messageID, text string, tags map[string]string)
+// MessageHandler receives the chat messages, channel is the lowercase login without #
+type MessageHandler func(channel string, message irc.PrivateMessage)
 
 type Client struct {
 	cfg       *config.Config
@@ -44,9 +45,7 @@ func NewClient(di *do.Injector) (*Client, error) {
 
 func (c *Client) setupIRCListeners() {
 	c.ircClient.OnPrivateMessage(func(message irc.PrivateMessage) {
-		username := strings.ToLower(message.User.Name)
 		channel := strings.ToLower(strings.TrimPrefix(message.Channel, "#"))
-		text := strings.TrimSpace(message.Message)
 
 		c.mutex.Lock()
 		handler := c.messageHandler
@@ -56,7 +55,7 @@ func (c *Client) setupIRCListeners() {
 			return
 		}
 
-		handler(channel, username, message.ID, text, message.Tags)
+		handler(channel, message)
 	})
 
 	c.ircClient.OnConnect(func() {
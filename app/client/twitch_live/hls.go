@@ -0,0 +1,397 @@
+package twitch_live
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// segments taken from the end of the first playlist, older ones are skipped to stay close to live
+	liveEdgeSegments = 2
+
+	segmentRetries      = 3
+	maxPlaylistFailures = 5
+	retryDelay          = 500 * time.Millisecond
+
+	stitchedAdClass = "twitch-stitched-ad"
+)
+
+// ErrStreamEnded is returned when the media playlist ends or disappears
+var ErrStreamEnded = errors.New("stream ended")
+
+type mediaPlaylist struct {
+	TargetDuration time.Duration
+	MediaSequence  int64
+	Segments       []mediaSegment
+	EndList        bool
+}
+
+type mediaSegment struct {
+	Sequence        int64
+	URL             string
+	Duration        time.Duration
+	Title           string
+	ProgramDateTime time.Time
+	// Discontinuity is set when the segment starts a new timeline, e.g. after an ad
+	Discontinuity bool
+	// Ad is set for segments stitched in by Twitch during ad breaks
+	Ad bool
+}
+
+type dateRange struct {
+	start    time.Time
+	duration time.Duration
+}
+
+func (r dateRange) contains(t time.Time) bool {
+	return !t.Before(r.start) && t.Before(r.start.Add(r.duration))
+}
+
+// parseAttributes parses an HLS attribute list, e.g. NAME="720p",BANDWIDTH=1000
+func parseAttributes(s string) map[string]string {
+	result := make(map[string]string)
+
+	for len(s) > 0 {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				value, s = s, ""
+			} else {
+				value, s = s[:end], s[end:]
+			}
+		}
+
+		result[key] = value
+		s = strings.TrimPrefix(s, ",")
+	}
+
+	return result
+}
+
+func parseMediaPlaylist(body, baseURL string) (*mediaPlaylist, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid playlist url: %w", err)
+	}
+
+	var (
+		playlist      mediaPlaylist
+		adRanges      []dateRange
+		next          mediaSegment
+		sequence      int64
+		sequenceFound bool
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lineNumber++
+
+		if lineNumber == 1 {
+			if line != "#EXTM3U" {
+				return nil, fmt.Errorf("not an m3u8 playlist")
+			}
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+
+		switch {
+		case line == "":
+		case tag == "#EXT-X-TARGETDURATION":
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target duration %q: %w", value, err)
+			}
+			playlist.TargetDuration = time.Duration(seconds * float64(time.Second))
+		case tag == "#EXT-X-MEDIA-SEQUENCE":
+			sequence, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid media sequence %q: %w", value, err)
+			}
+			playlist.MediaSequence = sequence
+			sequenceFound = true
+		case tag == "#EXT-X-DISCONTINUITY":
+			next.Discontinuity = true
+		case tag == "#EXT-X-PROGRAM-DATE-TIME":
+			if next.ProgramDateTime, err = time.Parse(time.RFC3339Nano, value); err != nil {
+				slog.Debug("Invalid program date time", "value", value)
+			}
+		case tag == "#EXT-X-DATERANGE":
+			attrs := parseAttributes(value)
+			if attrs["CLASS"] != stitchedAdClass {
+				continue
+			}
+
+			start, err := time.Parse(time.RFC3339Nano, attrs["START-DATE"])
+			if err != nil {
+				continue
+			}
+			seconds, _ := strconv.ParseFloat(attrs["DURATION"], 64)
+
+			adRanges = append(adRanges, dateRange{
+				start:    start,
+				duration: time.Duration(seconds * float64(time.Second)),
+			})
+		case tag == "#EXTINF":
+			durationStr, title, _ := strings.Cut(value, ",")
+			seconds, err := strconv.ParseFloat(durationStr, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid segment duration %q: %w", durationStr, err)
+			}
+			next.Duration = time.Duration(seconds * float64(time.Second))
+			next.Title = title
+		case tag == "#EXT-X-ENDLIST":
+			playlist.EndList = true
+		case strings.HasPrefix(line, "#"):
+			// other tags like EXT-X-TWITCH-PREFETCH are not needed
+		default:
+			segmentURL, err := base.Parse(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid segment url %q: %w", line, err)
+			}
+
+			next.URL = segmentURL.String()
+			next.Sequence = sequence
+			playlist.Segments = append(playlist.Segments, next)
+
+			sequence++
+			next = mediaSegment{}
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+
+	if !sequenceFound && len(playlist.Segments) == 0 && !playlist.EndList {
+		return nil, fmt.Errorf("media playlist has no segments")
+	}
+
+	for i := range playlist.Segments {
+		segment := &playlist.Segments[i]
+
+		if strings.Contains(segment.Title, "Amazon") {
+			segment.Ad = true
+			continue
+		}
+
+		if segment.ProgramDateTime.IsZero() {
+			continue
+		}
+
+		for _, adRange := range adRanges {
+			if adRange.contains(segment.ProgramDateTime) {
+				segment.Ad = true
+				break
+			}
+		}
+	}
+
+	return &playlist, nil
+}
+
+func (c *Client) fetchMediaPlaylist(ctx context.Context, playlistURL string) (*mediaPlaylist, error) {
+	body, status, err := c.get(ctx, playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return parseMediaPlaylist(string(body), playlistURL)
+	case http.StatusNotFound, http.StatusGone:
+		return nil, ErrStreamEnded
+	default:
+		return nil, fmt.Errorf("playlist returned status code %d", status)
+	}
+}
+
+func (c *Client) get(ctx context.Context, requestURL string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("NewRequestWithContext: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ReadAll: %w", err)
+	}
+
+	return body, resp.StatusCode, nil
+}
+
+func (c *Client) downloadSegment(ctx context.Context, segment mediaSegment) ([]byte, error) {
+	var lastErr error
+
+	for attempt := 1; attempt <= segmentRetries; attempt++ {
+		body, status, err := c.get(ctx, segment.URL)
+		if err == nil && status == http.StatusOK {
+			return body, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("segment returned status code %d", status)
+		}
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryDelay * time.Duration(attempt)):
+		}
+	}
+
+	return nil, lastErr
+}
+
+// StreamHLS polls the media playlist and writes the TS bytes of new segments to w until the stream ends.
+// Segments that fail to download are skipped, only repeated playlist failures stop the stream.
+// Ad segments are not written, onAdBreak is called when an ad break starts and ends.
+func (c *Client) StreamHLS(ctx context.Context, playlistURL string, w io.Writer, onAdBreak func(active bool)) error {
+	var (
+		lastSequence int64 = -1
+		failures     int
+		inAd         bool
+	)
+
+	defer func() {
+		if inAd {
+			onAdBreak(false)
+		}
+	}()
+
+	for {
+		playlist, err := c.fetchMediaPlaylist(ctx, playlistURL)
+		if errors.Is(err, ErrStreamEnded) {
+			return err
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			failures++
+			if failures >= maxPlaylistFailures {
+				return fmt.Errorf("failed to fetch playlist %d times: %w", failures, err)
+			}
+
+			slog.Warn("Failed to fetch media playlist", "failures", failures, "error", err)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(retryDelay * time.Duration(failures)):
+			}
+			continue
+		}
+		failures = 0
+
+		segments := playlist.Segments
+
+		// the sequence goes back when the stream restarts with a new playlist, it is joined at the live edge again
+		if len(segments) > 0 && segments[len(segments)-1].Sequence < lastSequence {
+			slog.Info("Media sequence was reset", "last", lastSequence, "new", playlist.MediaSequence)
+			lastSequence = -1
+		}
+
+		// finished playlists of VODs are played from the start
+		if lastSequence < 0 && !playlist.EndList && len(segments) > liveEdgeSegments {
+			segments = segments[len(segments)-liveEdgeSegments:]
+		}
+
+		newSegments := 0
+		for _, segment := range segments {
+			if segment.Sequence <= lastSequence {
+				continue
+			}
+
+			if lastSequence >= 0 && segment.Sequence > lastSequence+1 {
+				slog.Warn("Skipped segments that left the playlist", "count", segment.Sequence-lastSequence-1)
+			}
+			lastSequence = segment.Sequence
+			newSegments++
+
+			// the decoder copes with a new timeline by itself: the mpegts demuxer of ffmpeg corrects timestamp jumps,
+			// a change of the audio parameters reconfigures its resampler to the requested -ar / -ac, and only the -readrate
+			// pacing of replays looks at the corrected timestamps. Ad segments, the usual source of discontinuities,
+			// are not written at all.
+			if segment.Discontinuity {
+				slog.Debug("Playlist discontinuity", "sequence", segment.Sequence)
+			}
+			if segment.Ad != inAd {
+				inAd = segment.Ad
+				onAdBreak(inAd)
+			}
+			if segment.Ad {
+				continue
+			}
+
+			data, err := c.downloadSegment(ctx, segment)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				slog.Warn("Skipping segment", "sequence", segment.Sequence, "error", err)
+				continue
+			}
+
+			if _, err = w.Write(data); err != nil {
+				return fmt.Errorf("failed to write segment: %w", err)
+			}
+		}
+
+		if playlist.EndList {
+			return ErrStreamEnded
+		}
+
+		// reload after a full target duration when the playlist moved, after half of it otherwise
+		delay := playlist.TargetDuration
+		if newSegments == 0 {
+			delay /= 2
+		}
+		if delay <= 0 {
+			delay = time.Second
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
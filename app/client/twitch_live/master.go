@@ -0,0 +1,113 @@
+package twitch_live
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type StreamQuality struct {
+	// Quality is the rendition name, e.g. 720p60 or audio_only
+	Quality    string  `json:"quality"`
+	Resolution string  `json:"resolution"`
+	URL        string  `json:"url"`
+	Bandwidth  int     `json:"bandwidth"`
+	Codecs     string  `json:"codecs"`
+	FrameRate  float64 `json:"frame_rate"`
+	GroupID    string  `json:"group_id"`
+}
+
+// MasterPlaylist lists the renditions of a stream
+type MasterPlaylist struct {
+	Qualities []StreamQuality
+	// SessionData maps EXT-X-SESSION-DATA ids to their values
+	SessionData map[string]string
+	// TwitchInfo holds the EXT-X-TWITCH-INFO attributes
+	TwitchInfo map[string]string
+}
+
+func parseMasterPlaylist(body string) (*MasterPlaylist, error) {
+	playlist := MasterPlaylist{
+		SessionData: make(map[string]string),
+		TwitchInfo:  make(map[string]string),
+	}
+
+	// rendition names by group id, EXT-X-MEDIA comes before the streams referencing it
+	mediaNames := make(map[string]string)
+
+	var next *StreamQuality
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lineNumber++
+
+		if lineNumber == 1 {
+			if line != "#EXTM3U" {
+				return nil, fmt.Errorf("not an m3u8 playlist")
+			}
+			continue
+		}
+
+		tag, value, _ := strings.Cut(line, ":")
+
+		switch {
+		case line == "":
+		case tag == "#EXT-X-TWITCH-INFO":
+			for key, attr := range parseAttributes(value) {
+				playlist.TwitchInfo[key] = attr
+			}
+		case tag == "#EXT-X-SESSION-DATA":
+			attrs := parseAttributes(value)
+			if id := attrs["DATA-ID"]; id != "" {
+				playlist.SessionData[id] = attrs["VALUE"]
+			}
+		case tag == "#EXT-X-MEDIA":
+			attrs := parseAttributes(value)
+			if attrs["GROUP-ID"] != "" {
+				mediaNames[attrs["GROUP-ID"]] = attrs["NAME"]
+			}
+		case tag == "#EXT-X-STREAM-INF":
+			attrs := parseAttributes(value)
+
+			bandwidth, _ := strconv.Atoi(attrs["BANDWIDTH"])
+			frameRate, _ := strconv.ParseFloat(attrs["FRAME-RATE"], 64)
+
+			next = &StreamQuality{
+				Resolution: attrs["RESOLUTION"],
+				Bandwidth:  bandwidth,
+				Codecs:     attrs["CODECS"],
+				FrameRate:  frameRate,
+				GroupID:    attrs["VIDEO"],
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			if next == nil {
+				continue
+			}
+
+			next.URL = line
+			next.Quality = mediaNames[next.GroupID]
+			if next.Quality == "" {
+				next.Quality = next.GroupID
+			}
+
+			playlist.Qualities = append(playlist.Qualities, *next)
+			next = nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+
+	if len(playlist.Qualities) == 0 {
+		return nil, fmt.Errorf("master playlist has no streams")
+	}
+
+	return &playlist, nil
+}
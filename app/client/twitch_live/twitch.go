@@ -32,13 +32,8 @@ type AccessToken struct {
 	Signature string `json:"signature"`
 }
 
-type StreamQuality struct {
-	Quality    string `json:"quality"`
-	Resolution string `json:"resolution"`
-	URL        string `json:"url"`
-}
-
-func (c *Client) getAccessToken(ctx context.Context, id string) (*AccessToken, error) {
+// getAccessToken returns the playback token of a live channel login or of a VOD id
+func (c *Client) getAccessToken(ctx context.Context, id string, isVod bool) (*AccessToken, error) {
 	type persistedQuery struct {
 		Version    int    `json:"version"`
 		Sha256Hash string `json:"sha256Hash"`
@@ -63,10 +58,15 @@ func (c *Client) getAccessToken(ctx context.Context, id string) (*AccessToken, e
 	}
 
 	variablesData := variables{
-		Login:      id,
-		IsLive:     true,
+		IsLive:     !isVod,
+		IsVod:      isVod,
 		PlayerType: "embed",
 	}
+	if isVod {
+		variablesData.VodID = id
+	} else {
+		variablesData.Login = id
+	}
 
 	requestData := requestBody{
 		OperationName: "PlaybackAccessToken",
@@ -122,15 +122,28 @@ func (c *Client) getAccessToken(ctx context.Context, id string) (*AccessToken, e
 	}
 
 	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("json.Unmarshal", resp.StatusCode)
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
 	}
 
-	return response.Data.StreamPlaybackAccessToken, nil
+	token := response.Data.StreamPlaybackAccessToken
+	if isVod {
+		token = response.Data.VideoPlaybackAccessToken
+	}
+	if token == nil {
+		return nil, fmt.Errorf("no playback access token in response")
+	}
+
+	return token, nil
 }
 
-func (c *Client) getPlaylist(ctx context.Context, id string, accessToken *AccessToken) (string, error) {
+func (c *Client) getPlaylist(ctx context.Context, id string, isVod bool, accessToken *AccessToken) (string, error) {
+	path := "api/channel/hls"
+	if isVod {
+		path = "vod"
+	}
+
 	url := fmt.Sprintf("https://usher.ttvnw.net/%s/%s.m3u8?client_id=%s&token=%s&sig=%s&allow_source=true&allow_audio_only=true",
-		"api/channel/hls",
+		path,
 		id,
 		clientId,
 		accessToken.Value,
@@ -164,55 +177,25 @@ func (c *Client) getPlaylist(ctx context.Context, id string, accessToken *Access
 	}
 }
 
-func parsePlaylist(playlist string) []StreamQuality {
-	parsedPlaylist := []StreamQuality{}
-	lines := strings.Split(playlist, "\n")
-
-	for i := 4; i < len(lines); i += 3 {
-		if i-2 < 0 || i-1 < 0 || i >= len(lines) {
-			continue
-		}
-
-		qualityLine := lines[i-2]
-		resolutionLine := lines[i-1]
-		urlLine := lines[i]
-
-		quality := ""
-		if strings.Contains(qualityLine, "NAME=\"") {
-			parts := strings.Split(qualityLine, "NAME=\"")
-			if len(parts) > 1 {
-				quality = strings.Split(parts[1], "\"")[0]
-			}
-		}
-
-		resolution := ""
-		if strings.Contains(resolutionLine, "RESOLUTION=") {
-			parts := strings.Split(resolutionLine, "RESOLUTION=")
-			if len(parts) > 1 {
-				resolution = strings.Split(parts[1], ",")[0]
-			}
-		}
-
-		parsedPlaylist = append(parsedPlaylist, StreamQuality{
-			Quality:    quality,
-			Resolution: resolution,
-			URL:        urlLine,
-		})
-	}
+func (c *Client) GetM3U8(ctx context.Context, channel string) (*MasterPlaylist, error) {
+	return c.getMasterPlaylist(ctx, channel, false)
+}
 
-	return parsedPlaylist
+// GetVodM3U8 returns the master playlist of a past broadcast
+func (c *Client) GetVodM3U8(ctx context.Context, vodID string) (*MasterPlaylist, error) {
+	return c.getMasterPlaylist(ctx, vodID, true)
 }
 
-func (c *Client) GetM3U8(ctx context.Context, channel string) ([]StreamQuality, error) {
-	accessToken, err := c.getAccessToken(ctx, channel)
+func (c *Client) getMasterPlaylist(ctx context.Context, id string, isVod bool) (*MasterPlaylist, error) {
+	accessToken, err := c.getAccessToken(ctx, id, isVod)
 	if err != nil {
 		return nil, fmt.Errorf("getAccessToken: %w", err)
 	}
 
-	playlist, err := c.getPlaylist(ctx, channel, accessToken)
+	playlist, err := c.getPlaylist(ctx, id, isVod, accessToken)
 	if err != nil {
 		return nil, fmt.Errorf("getPlaylist: %w", err)
 	}
 
-	return parsePlaylist(playlist), nil
+	return parseMasterPlaylist(playlist)
 }
@@ -0,0 +1,204 @@
+package whisper
+
+import (
+	"context"
+	"durkalive/app/client/stt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+const (
+	windowDuration = 30 * time.Millisecond
+	// preRoll of silence kept before the speech so the first word is not cut
+	preRoll = 300 * time.Millisecond
+	// chunks with less speech are dropped as noise
+	minSpeech = 250 * time.Millisecond
+	// chunks waiting for the server, newer chunks are dropped when the server falls behind
+	maxPendingChunks = 8
+)
+
+// session splits the audio into chunks on pauses and transcribes them one by one
+type session struct {
+	client *Client
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	buf      []byte
+	analyzed int
+	speech   time.Duration
+	silence  time.Duration
+
+	chunks  chan []byte
+	results chan string
+}
+
+func newSession(ctx context.Context, client *Client) *session {
+	ctx, cancel := context.WithCancel(ctx)
+
+	s := &session{
+		client:  client,
+		ctx:     ctx,
+		cancel:  cancel,
+		chunks:  make(chan []byte, maxPendingChunks),
+		results: make(chan string),
+	}
+
+	go s.run()
+
+	return s
+}
+
+func (s *session) Send(pcm []byte) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	s.buf = append(s.buf, pcm...)
+
+	windowBytes := stt.DurationToBytes(windowDuration)
+
+	for len(s.buf)-s.analyzed >= windowBytes {
+		window := s.buf[s.analyzed : s.analyzed+windowBytes]
+		s.analyzed += windowBytes
+
+		if stt.RMS(window) >= float64(s.client.cfg.SilenceThreshold) {
+			s.speech += windowDuration
+			s.silence = 0
+		} else {
+			s.silence += windowDuration
+		}
+
+		switch {
+		case s.speech == 0:
+			if preRollBytes := stt.DurationToBytes(preRoll); s.analyzed > preRollBytes {
+				s.buf = s.buf[s.analyzed-preRollBytes:]
+				s.analyzed = preRollBytes
+			}
+		case s.silence >= s.client.cfg.MinSilence, stt.BytesToDuration(s.analyzed) >= s.client.cfg.MaxChunk:
+			s.enqueue(s.cut())
+		}
+	}
+
+	return nil
+}
+
+// Flush ends the chunk without waiting for min_silence, no more audio comes until the next speech
+func (s *session) Flush() error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	s.enqueue(s.cut())
+
+	return nil
+}
+
+func (s *session) enqueue(chunk []byte) {
+	if chunk == nil {
+		return
+	}
+
+	select {
+	case s.chunks <- chunk:
+	default:
+		slog.Warn("Whisper server is falling behind, dropping audio chunk",
+			"duration", stt.BytesToDuration(len(chunk)))
+	}
+}
+
+// CloseSend queues the analyzed rest of the audio, waiting for the server instead of dropping it
+func (s *session) CloseSend() error {
+	defer close(s.chunks)
+
+	chunk := s.cut()
+	if chunk == nil {
+		return nil
+	}
+
+	select {
+	case s.chunks <- chunk:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// cut removes the analyzed audio from the buffer, it returns nil if the audio has too little speech
+func (s *session) cut() []byte {
+	chunk := make([]byte, s.analyzed)
+	copy(chunk, s.buf[:s.analyzed])
+
+	s.buf = append(s.buf[:0], s.buf[s.analyzed:]...)
+	s.analyzed = 0
+
+	speech := s.speech
+	s.speech = 0
+	s.silence = 0
+
+	if speech < minSpeech {
+		return nil
+	}
+
+	return chunk
+}
+
+func (s *session) run() {
+	defer close(s.results)
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case chunk, ok := <-s.chunks:
+			if !ok {
+				return
+			}
+
+			start := time.Now()
+
+			text, err := s.client.transcribe(s.ctx, chunk)
+			if err != nil {
+				if s.ctx.Err() != nil {
+					return
+				}
+
+				slog.Warn("Failed to transcribe audio chunk", "error", err)
+				continue
+			}
+
+			slog.Debug("Transcribed audio chunk",
+				"duration", stt.BytesToDuration(len(chunk)),
+				"took", time.Since(start),
+				"text", text)
+
+			if text == "" {
+				continue
+			}
+
+			select {
+			case s.results <- text:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// Recv returns whole chunks as final phrases, whisper has no partial results
+func (s *session) Recv() ([]stt.Phrase, error) {
+	text, ok := <-s.results
+	if !ok {
+		if err := s.ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	return []stt.Phrase{{Text: text, Final: true}}, nil
+}
+
+func (s *session) Close() error {
+	s.cancel()
+	return nil
+}
@@ -0,0 +1,33 @@
+package whisper
+
+import (
+	"durkalive/app/client/stt"
+	"encoding/binary"
+)
+
+const wavHeaderSize = 44
+
+// encodeWAV wraps mono PCM into a minimal RIFF/WAVE container
+func encodeWAV(pcm []byte) []byte {
+	const byteRate = stt.SampleRate * stt.BytesPerSample
+
+	buf := make([]byte, wavHeaderSize, wavHeaderSize+len(pcm))
+
+	copy(buf[0:], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:], uint32(wavHeaderSize-8+len(pcm)))
+	copy(buf[8:], "WAVE")
+
+	copy(buf[12:], "fmt ")
+	binary.LittleEndian.PutUint32(buf[16:], 16)
+	binary.LittleEndian.PutUint16(buf[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(buf[22:], 1) // mono
+	binary.LittleEndian.PutUint32(buf[24:], stt.SampleRate)
+	binary.LittleEndian.PutUint32(buf[28:], byteRate)
+	binary.LittleEndian.PutUint16(buf[32:], stt.BytesPerSample)
+	binary.LittleEndian.PutUint16(buf[34:], stt.BytesPerSample*8)
+
+	copy(buf[36:], "data")
+	binary.LittleEndian.PutUint32(buf[40:], uint32(len(pcm)))
+
+	return append(buf, pcm...)
+}
@@ -0,0 +1,61 @@
+package whisper
+
+import (
+	"bytes"
+	"context"
+	"durkalive/app/client/stt"
+	"durkalive/app/config"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/samber/do"
+	"github.com/sashabaranov/go-openai"
+)
+
+const requestTimeout = 60 * time.Second
+
+// Client talks to a whisper.cpp / faster-whisper server with an OpenAI-compatible /audio/transcriptions endpoint
+type Client struct {
+	cfg    *config.Whisper
+	client *openai.Client
+}
+
+func NewClient(di *do.Injector) (*Client, error) {
+	cfg := do.MustInvoke[*config.Config](di)
+
+	if cfg.STT.Whisper == nil {
+		return nil, fmt.Errorf("whisper server is not configured")
+	}
+
+	clientConfig := openai.DefaultConfig(cfg.STT.Whisper.Token)
+	clientConfig.BaseURL = cfg.STT.Whisper.BaseURL
+	clientConfig.HTTPClient = &http.Client{
+		Timeout: requestTimeout,
+	}
+
+	return &Client{
+		cfg:    cfg.STT.Whisper,
+		client: openai.NewClientWithConfig(clientConfig),
+	}, nil
+}
+
+func (c *Client) Start(ctx context.Context) (stt.Session, error) {
+	return newSession(ctx, c), nil
+}
+
+func (c *Client) transcribe(ctx context.Context, pcm []byte) (string, error) {
+	resp, err := c.client.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    c.cfg.Model,
+		FilePath: "chunk.wav",
+		Reader:   bytes.NewReader(encodeWAV(pcm)),
+		Language: c.cfg.Language,
+		Format:   openai.AudioResponseFormatJSON,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create transcription: %w", err)
+	}
+
+	return strings.TrimSpace(resp.Text), nil
+}
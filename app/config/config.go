@@ -2,6 +2,9 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/samber/oops"
@@ -12,13 +15,79 @@ type Config struct {
 	Log    Log    `yaml:"log"`
 	DB     DB     `yaml:"db"`
 	Yandex Yandex `yaml:"yandex"`
+	STT    STT    `yaml:"stt"`
 	Twitch Twitch `yaml:"twitch"`
 	OpenAI OpenAI `yaml:"openai"`
+	Memory Memory `yaml:"memory"`
+
+	Conversation Conversation `yaml:"conversation"`
+	Prompts      Prompts      `yaml:"prompts"`
+	Personas     []Persona    `yaml:"personas" validate:"dive"`
+}
+
+type Persona struct {
+	// Unique name of the bot character
+	Name string `yaml:"name" example:"Дурка" validate:"required"`
+	// Other names that count as mentions of the bot
+	Aliases []string `yaml:"aliases" example:"дурка"`
+	// Language of the replies
+	Language string `yaml:"language" example:"русский" validate:"required"`
+	// Rules describing the behavior and the tone of the character
+	Tone []string `yaml:"tone" example:"Всегда сохраняй вежливый, доброжелательный и слегка любопытный тон."`
+	// Topics the character never talks about
+	BannedTopics []string `yaml:"banned_topics" example:"политика"`
+	// Example messages written by the character
+	Examples []string `yaml:"examples" example:"а что за игра кстати?"`
+}
+
+type Prompts struct {
+	// Path to the decision prompt template, the embedded one is used if empty
+	Decision string `yaml:"decision" example:"prompts/decision.tmpl"`
+	// Path to the reply prompt template, the embedded one is used if empty
+	Reply string `yaml:"reply" example:"prompts/reply.tmpl"`
+}
+
+type Conversation struct {
+	// Minimal decision confidence required to reply, a decision without confidence never passes it.
+	// Mentions and thread replies to the bot bypass it.
+	MinConfidence *float64 `yaml:"min_confidence" example:"0.8" validate:"omitempty,gte=0,lte=1"`
+	// Minimal gap between two replies, zero disables it. Mentions and thread replies to the bot bypass it.
+	MinReplyGap *time.Duration `yaml:"min_reply_gap" example:"1m" validate:"omitempty,gte=0"`
+	// Max number of replies per reply window, mentions and thread replies to the bot bypass it
+	MaxReplies int `yaml:"max_replies" example:"5" validate:"gte=1"`
+	// Window for max_replies
+	ReplyWindow time.Duration `yaml:"reply_window" example:"10m" validate:"gte=0"`
+	// Minimal gap between two replies to the same viewer, mentions and thread replies to the bot don't bypass it
+	UserCooldown time.Duration `yaml:"user_cooldown" example:"2m" validate:"gte=0"`
+	// Directory of the shadow mode session transcripts
+	TranscriptDir string `yaml:"transcript_dir" example:"data/transcripts"`
+	// Operator approval of the replies before they are posted
+	Approval Approval `yaml:"approval"`
+}
+
+type Approval struct {
+	// Send the replies to the operator in telegram with approve / edit / reject buttons
+	Enabled bool `yaml:"enabled" example:"true"`
+	// Chat bot token, log.telegram.token is used if empty
+	Token string `yaml:"token" example:"1234567890:ABCdefGHIjklMNopQRstUVwxyZ-123456789"`
+	// Chat ID of the operator, log.telegram.chat_id is used if empty
+	ChatID string `yaml:"chat_id" example:"1001234567890"`
+	// Replies not reviewed in time are discarded as stale
+	Timeout time.Duration `yaml:"timeout" example:"1m" validate:"gte=0"`
 }
 
 type OpenAI struct {
 	Decision ModelConfig `yaml:"decision" validate:"required"`
 	Reply    ModelConfig `yaml:"reply" validate:"required"`
+	// Embedding model, required for semantic fact retrieval
+	Embedding *EmbeddingConfig `yaml:"embedding" validate:"omitempty"`
+}
+
+type Memory struct {
+	// How facts are picked for prompts: all or semantic
+	Retrieval string `yaml:"retrieval" example:"all" validate:"oneof=all semantic"`
+	// Number of facts injected into prompts in semantic mode
+	TopK int `yaml:"top_k" example:"20" validate:"min=1"`
 }
 
 type ModelConfig struct {
@@ -30,6 +99,54 @@ type ModelConfig struct {
 	Model string `yaml:"model" example:"deepseek/deepseek-chat-v3-0324:free" validate:"required"`
 }
 
+type EmbeddingConfig struct {
+	// OpenAI base url
+	BaseURL string `yaml:"base_url" example:"https://api.openai.com/v1" validate:"required"`
+	// OpenAI token
+	Token string `yaml:"token" example:"sk-proj-abc123456789DEF789ghi012JKL345mno678PQR901stu234VWX" validate:"required"`
+	// OpenAI embedding model
+	Model string `yaml:"model" example:"text-embedding-3-small" validate:"required"`
+}
+
+type STT struct {
+	// Speech to text provider: speechkit or whisper
+	Provider string `yaml:"provider" example:"whisper" validate:"oneof=speechkit whisper"`
+	// Whisper server, required for the whisper provider
+	Whisper *Whisper `yaml:"whisper" validate:"omitempty"`
+	// Voice activity detection in front of the provider
+	VAD VAD `yaml:"vad"`
+}
+
+type VAD struct {
+	// Send only the audio with speech to the provider
+	Enabled bool `yaml:"enabled" example:"true"`
+	// RMS amplitude above which audio counts as speech
+	Threshold int `yaml:"threshold" example:"600" validate:"gte=0"`
+	// Continuous speech required to open the gate
+	MinSpeech time.Duration `yaml:"min_speech" example:"200ms" validate:"gte=0"`
+	// Audio still sent after the speech stops
+	Hangover time.Duration `yaml:"hangover" example:"1s" validate:"gte=0"`
+	// Audio sent before the start of the speech
+	PreRoll time.Duration `yaml:"pre_roll" example:"300ms" validate:"gte=0"`
+}
+
+type Whisper struct {
+	// Base url of an OpenAI-compatible /audio/transcriptions server
+	BaseURL string `yaml:"base_url" example:"http://localhost:8000/v1" validate:"required"`
+	// Token of the server, may be empty for local servers
+	Token string `yaml:"token" example:"sk-local"`
+	// Transcription model
+	Model string `yaml:"model" example:"whisper-1" validate:"required"`
+	// Language of the speech
+	Language string `yaml:"language" example:"ru"`
+	// RMS amplitude below which audio counts as silence
+	SilenceThreshold int `yaml:"silence_threshold" example:"500" validate:"gte=0"`
+	// Pause that ends a chunk
+	MinSilence time.Duration `yaml:"min_silence" example:"700ms" validate:"gte=0"`
+	// Max duration of a chunk
+	MaxChunk time.Duration `yaml:"max_chunk" example:"15s" validate:"gte=0"`
+}
+
 type Yandex struct {
 	SpeechKit SpeechKit `yaml:"speech_kit"`
 }
@@ -44,14 +161,45 @@ type Twitch struct {
 	ClientSecret string `yaml:"client_secret" example:"abc123def456ghi789jkl012mno345pqr678stu901" validate:"required"`
 	// Username of the bot account
 	Username string `yaml:"username" example:"PogChamp123" validate:"required"`
-	// Channel name of the channel
-	Channel string `yaml:"channel" example:"PogChamp123" validate:"required"`
+	// Channels the bot watches
+	Channels []Channel `yaml:"channels" validate:"required,min=1,dive"`
 	// User refresh token of the bot account
 	RefreshToken string `yaml:"refresh_token" example:"v1.abc123def456ghi789jkl012mno345pqr678stu901vwx234yz567" validate:"required"`
+	// Preferred stream qualities in order, the lowest bandwidth one is used if none is available
+	Qualities []string `yaml:"qualities" example:"audio_only"`
 	// Disable notifications
 	DisableNotifications bool `yaml:"disable_notifications" example:"false"`
 	// Ignore chat
 	IgnoreChat bool `yaml:"ignore_chat" example:"false"`
+	// Post the replies to chat messages in their thread, the replies start with @username otherwise
+	ThreadReplies bool `yaml:"thread_replies" example:"true"`
+	// Stream status polling interval right after the channel goes offline, grows up to max_offline_poll
+	OfflinePoll time.Duration `yaml:"offline_poll" example:"10s" validate:"gte=0"`
+	// Max stream status polling interval while the channel is offline
+	MaxOfflinePoll time.Duration `yaml:"max_offline_poll" example:"1m" validate:"gte=0"`
+	// Refresh interval of the stream title, category, tags and viewer count shown to the bot
+	StreamInfoInterval time.Duration `yaml:"stream_info_interval" example:"1m" validate:"gte=0"`
+	// Stream and channel events
+	EventSub EventSub `yaml:"eventsub"`
+}
+
+type EventSub struct {
+	// Subscribe to stream online / offline, category changes, raids, follows, subscriptions, cheers and redemptions
+	Enabled bool `yaml:"enabled" example:"true"`
+	// EventSub websocket url, ws://127.0.0.1:8080/ws for the twitch cli mock server
+	URL string `yaml:"url" example:"wss://eventsub.wss.twitch.tv/ws"`
+	// Subscriptions API url, http://127.0.0.1:8080/eventsub/subscriptions for the twitch cli mock server
+	SubscriptionsURL string `yaml:"subscriptions_url" example:"https://api.twitch.tv/helix/eventsub/subscriptions"`
+}
+
+type Channel struct {
+	// Channel name
+	Name string `yaml:"name" example:"PogChamp123" validate:"required"`
+	// Name of the persona from the personas list, the built-in one is used if empty
+	Persona string `yaml:"persona" example:"Дурка"`
+	// Never post replies nor change the database and the memory, write every decision and reply
+	// to the session transcript instead
+	Shadow bool `yaml:"shadow" example:"false"`
 }
 
 type Log struct {
@@ -89,6 +237,22 @@ func Load() (*Config, error) {
 		return nil, oops.Errorf("failed to parse YAML config: %w", err)
 	}
 
+	// twitch.channel was replaced by the twitch.channels list
+	var legacy struct {
+		Twitch struct {
+			Channel string `yaml:"channel"`
+		} `yaml:"twitch"`
+	}
+	if err = yaml.Unmarshal(data, &legacy); err != nil {
+		return nil, oops.Errorf("failed to parse YAML config: %w", err)
+	}
+	if legacy.Twitch.Channel != "" {
+		if len(result.Twitch.Channels) > 0 {
+			return nil, oops.Errorf("twitch.channel was replaced by twitch.channels, remove it")
+		}
+		result.Twitch.Channels = []Channel{{Name: legacy.Twitch.Channel}}
+	}
+
 	if result.DB.User == "" {
 		result.DB.User = "postgres"
 	}
@@ -102,10 +266,134 @@ func Load() (*Config, error) {
 		result.DB.Database = "durkalive"
 	}
 
+	if result.Twitch.EventSub.URL == "" {
+		result.Twitch.EventSub.URL = "wss://eventsub.wss.twitch.tv/ws"
+	}
+	if result.Twitch.EventSub.SubscriptionsURL == "" {
+		result.Twitch.EventSub.SubscriptionsURL = "https://api.twitch.tv/helix/eventsub/subscriptions"
+	}
+
+	if result.Twitch.OfflinePoll == 0 {
+		result.Twitch.OfflinePoll = 10 * time.Second
+	}
+	if result.Twitch.MaxOfflinePoll == 0 {
+		result.Twitch.MaxOfflinePoll = time.Minute
+	}
+	result.Twitch.MaxOfflinePoll = max(result.Twitch.MaxOfflinePoll, result.Twitch.OfflinePoll)
+	if result.Twitch.StreamInfoInterval == 0 {
+		result.Twitch.StreamInfoInterval = time.Minute
+	}
+
+	if len(result.Twitch.Qualities) == 0 {
+		result.Twitch.Qualities = []string{"audio_only"}
+	}
+
+	if result.STT.Provider == "" {
+		result.STT.Provider = "speechkit"
+	}
+	if result.STT.VAD.Threshold == 0 {
+		result.STT.VAD.Threshold = 600
+	}
+	if result.STT.VAD.MinSpeech == 0 {
+		result.STT.VAD.MinSpeech = 200 * time.Millisecond
+	}
+	if result.STT.VAD.Hangover == 0 {
+		result.STT.VAD.Hangover = time.Second
+	}
+	if result.STT.VAD.PreRoll == 0 {
+		result.STT.VAD.PreRoll = 300 * time.Millisecond
+	}
+	if result.STT.Whisper != nil {
+		if result.STT.Whisper.SilenceThreshold == 0 {
+			result.STT.Whisper.SilenceThreshold = 500
+		}
+		if result.STT.Whisper.MinSilence == 0 {
+			result.STT.Whisper.MinSilence = 700 * time.Millisecond
+		}
+		if result.STT.Whisper.MaxChunk == 0 {
+			result.STT.Whisper.MaxChunk = 15 * time.Second
+		}
+	}
+
+	if result.Memory.Retrieval == "" {
+		result.Memory.Retrieval = "all"
+	}
+	if result.Memory.TopK == 0 {
+		result.Memory.TopK = 20
+	}
+
+	if result.Conversation.MinConfidence == nil {
+		minConfidence := 0.8
+		result.Conversation.MinConfidence = &minConfidence
+	}
+	if result.Conversation.MinReplyGap == nil {
+		minReplyGap := time.Minute
+		result.Conversation.MinReplyGap = &minReplyGap
+	}
+	if result.Conversation.MaxReplies == 0 {
+		result.Conversation.MaxReplies = 5
+	}
+	if result.Conversation.ReplyWindow == 0 {
+		result.Conversation.ReplyWindow = 10 * time.Minute
+	}
+	if result.Conversation.UserCooldown == 0 {
+		result.Conversation.UserCooldown = 2 * time.Minute
+	}
+	if result.Conversation.TranscriptDir == "" {
+		result.Conversation.TranscriptDir = filepath.Join("data", "transcripts")
+	}
+	if result.Conversation.Approval.Token == "" {
+		result.Conversation.Approval.Token = result.Log.Telegram.Token
+	}
+	if result.Conversation.Approval.ChatID == "" {
+		result.Conversation.Approval.ChatID = result.Log.Telegram.ChatID
+	}
+	if result.Conversation.Approval.Timeout == 0 {
+		result.Conversation.Approval.Timeout = time.Minute
+	}
+
 	validate := validator.New(validator.WithRequiredStructEnabled())
 	if err := validate.Struct(result); err != nil {
 		return nil, oops.Errorf("failed to validate config: %w", err)
 	}
 
+	seenChannels := make(map[string]bool)
+	for i := range result.Twitch.Channels {
+		channel := &result.Twitch.Channels[i]
+		channel.Name = strings.ToLower(channel.Name)
+
+		if seenChannels[channel.Name] {
+			return nil, oops.Errorf("channel %q is listed twice", channel.Name)
+		}
+		seenChannels[channel.Name] = true
+
+		if channel.Persona != "" && !hasPersona(result.Personas, channel.Persona) {
+			return nil, oops.Errorf("persona %q of channel %q is not defined", channel.Persona, channel.Name)
+		}
+	}
+
+	if result.STT.Provider == "whisper" && result.STT.Whisper == nil {
+		return nil, oops.Errorf("stt.whisper is required for the whisper provider")
+	}
+
+	if result.Conversation.Approval.Enabled &&
+		(result.Conversation.Approval.Token == "" || result.Conversation.Approval.ChatID == "") {
+		return nil, oops.Errorf("telegram token and chat id are required for reply approval")
+	}
+
+	if result.Memory.Retrieval == "semantic" && result.OpenAI.Embedding == nil {
+		return nil, oops.Errorf("openai.embedding is required for semantic memory retrieval")
+	}
+
 	return &result, nil
 }
+
+func hasPersona(personas []Persona, name string) bool {
+	for _, persona := range personas {
+		if persona.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
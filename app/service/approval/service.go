@@ -0,0 +1,276 @@
+package approval
+
+import (
+	"context"
+	"durkalive/app/config"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/samber/do"
+)
+
+const (
+	actionApprove = "approve"
+	actionEdit    = "edit"
+	actionReject  = "reject"
+)
+
+// Outcome of an approval request
+type Outcome string
+
+const (
+	OutcomeApproved Outcome = "approved"
+	OutcomeEdited   Outcome = "edited"
+	OutcomeRejected Outcome = "rejected"
+	// OutcomeExpired means the operator did not answer in time and the reply is stale
+	OutcomeExpired Outcome = "expired"
+)
+
+type Request struct {
+	Channel  string
+	Username string
+	Text     string
+	Reply    string
+}
+
+type Result struct {
+	Outcome Outcome
+	// Text is the reply to post, the operator's version for OutcomeEdited
+	Text string
+	// Operator is the telegram username of whoever answered
+	Operator string
+	// Latency is the time the operator took to answer
+	Latency time.Duration
+}
+
+// Service asks the operator in telegram to approve, edit or reject the replies before they are posted
+type Service struct {
+	cfg    *config.Config
+	bot    *tgbotapi.BotAPI
+	chatID int64
+
+	mu sync.Mutex
+	// pending requests by the telegram message id of the request and of the edit prompt
+	pending map[int]*pendingRequest
+}
+
+type pendingRequest struct {
+	request   Request
+	messageID int
+	startedAt time.Time
+	done      chan Result
+}
+
+func New(di *do.Injector) (*Service, error) {
+	cfg := do.MustInvoke[*config.Config](di)
+
+	s := &Service{
+		cfg:     cfg,
+		pending: make(map[int]*pendingRequest),
+	}
+
+	if !cfg.Conversation.Approval.Enabled {
+		return s, nil
+	}
+
+	chatID, err := strconv.ParseInt(cfg.Conversation.Approval.ChatID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid approval chat id: %w", err)
+	}
+
+	bot, err := tgbotapi.NewBotAPI(cfg.Conversation.Approval.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create telegram bot: %w", err)
+	}
+
+	s.bot = bot
+	s.chatID = chatID
+
+	return s, nil
+}
+
+func (s *Service) Enabled() bool {
+	return s.bot != nil
+}
+
+// Request sends the reply to the operator and waits for the answer or the timeout
+func (s *Service) Request(ctx context.Context, request Request) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	msg := tgbotapi.NewMessage(s.chatID, formatRequest(request))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Approve", actionApprove),
+		tgbotapi.NewInlineKeyboardButtonData("Edit", actionEdit),
+		tgbotapi.NewInlineKeyboardButtonData("Reject", actionReject),
+	))
+
+	sent, err := s.bot.Send(msg)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to send approval request: %w", err)
+	}
+
+	p := &pendingRequest{
+		request:   request,
+		messageID: sent.MessageID,
+		startedAt: time.Now(),
+		done:      make(chan Result, 1),
+	}
+
+	s.mu.Lock()
+	s.pending[sent.MessageID] = p
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.cfg.Conversation.Approval.Timeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-p.done:
+		return result, nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	// the operator may have answered at the same moment, the first result wins
+	s.resolve(p, Result{Outcome: OutcomeExpired})
+
+	return <-p.done, ctx.Err()
+}
+
+// RunLoop handles the buttons and the edited replies of the operator
+func (s *Service) RunLoop(ctx context.Context) {
+	if !s.Enabled() {
+		return
+	}
+
+	updateCfg := tgbotapi.NewUpdate(0)
+	updateCfg.Timeout = 30
+
+	updates := s.bot.GetUpdatesChan(updateCfg)
+	defer s.bot.StopReceivingUpdates()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update := <-updates:
+			switch {
+			case update.CallbackQuery != nil:
+				s.handleCallback(update.CallbackQuery)
+			case update.Message != nil:
+				s.handleMessage(update.Message)
+			}
+		}
+	}
+}
+
+func (s *Service) handleCallback(query *tgbotapi.CallbackQuery) {
+	if _, err := s.bot.Request(tgbotapi.NewCallback(query.ID, "")); err != nil {
+		slog.Warn("Failed to answer telegram callback", "error", err)
+	}
+
+	if query.Message == nil || query.Message.Chat.ID != s.chatID {
+		return
+	}
+
+	s.mu.Lock()
+	p, ok := s.pending[query.Message.MessageID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	operator := ""
+	if query.From != nil {
+		operator = query.From.UserName
+	}
+
+	switch query.Data {
+	case actionApprove:
+		s.resolve(p, Result{Outcome: OutcomeApproved, Text: p.request.Reply, Operator: operator})
+	case actionReject:
+		s.resolve(p, Result{Outcome: OutcomeRejected, Operator: operator})
+	case actionEdit:
+		prompt := tgbotapi.NewMessage(s.chatID, "Reply to this message with the new text")
+		prompt.ReplyToMessageID = p.messageID
+		prompt.ReplyMarkup = tgbotapi.ForceReply{
+			ForceReply:            true,
+			InputFieldPlaceholder: p.request.Reply,
+		}
+
+		sent, err := s.bot.Send(prompt)
+		if err != nil {
+			slog.Warn("Failed to send edit prompt", "error", err)
+			return
+		}
+
+		s.mu.Lock()
+		if _, ok = s.pending[p.messageID]; ok {
+			s.pending[sent.MessageID] = p
+		}
+		s.mu.Unlock()
+	}
+}
+
+// handleMessage treats a reply to the request or to the edit prompt as the edited text
+func (s *Service) handleMessage(message *tgbotapi.Message) {
+	if message.Chat == nil || message.Chat.ID != s.chatID || message.ReplyToMessage == nil || message.Text == "" {
+		return
+	}
+
+	s.mu.Lock()
+	p, ok := s.pending[message.ReplyToMessage.MessageID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	operator := ""
+	if message.From != nil {
+		operator = message.From.UserName
+	}
+
+	s.resolve(p, Result{Outcome: OutcomeEdited, Text: message.Text, Operator: operator})
+}
+
+// resolve completes the request once, returns false if it is already done
+func (s *Service) resolve(p *pendingRequest, result Result) bool {
+	s.mu.Lock()
+	if _, ok := s.pending[p.messageID]; !ok {
+		s.mu.Unlock()
+		return false
+	}
+	for id, other := range s.pending {
+		if other == p {
+			delete(s.pending, id)
+		}
+	}
+	s.mu.Unlock()
+
+	result.Latency = time.Since(p.startedAt)
+	p.done <- result
+
+	status := string(result.Outcome)
+	if result.Operator != "" {
+		status += " by @" + result.Operator
+	}
+	if result.Outcome == OutcomeEdited {
+		status += ":\n" + result.Text
+	}
+
+	edit := tgbotapi.NewEditMessageText(s.chatID, p.messageID, formatRequest(p.request)+"\n\n"+status)
+	if _, err := s.bot.Send(edit); err != nil {
+		slog.Warn("Failed to update approval request", "error", err)
+	}
+
+	return true
+}
+
+func formatRequest(request Request) string {
+	return fmt.Sprintf("#%s\n%s: %s\n\nReply: %s", request.Channel, request.Username, request.Text, request.Reply)
+}
@@ -0,0 +1,52 @@
+package conversation
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"durkalive/app/service/approval"
+	"durkalive/app/service/storage"
+)
+
+func (s *Service) needsApproval(ch *Channel) bool {
+	return s.approvalSvc.Enabled() && !ch.shadow && !s.cfg.Twitch.DisableNotifications
+}
+
+// approve asks the operator about the reply, returns an empty text if nothing must be posted
+func (s *Service) approve(ctx context.Context, ch *Channel, messageID int64, username, text, reply string) (string, error) {
+	result, err := s.approvalSvc.Request(ctx, approval.Request{
+		Channel:  ch.name,
+		Username: username,
+		Text:     text,
+		Reply:    reply,
+	})
+	if err != nil {
+		return "", fmt.Errorf("approvalSvc.Request: %w", err)
+	}
+
+	slog.Info("Reply reviewed",
+		"channel", ch.name,
+		"outcome", result.Outcome,
+		"operator", result.Operator,
+		"latency", result.Latency)
+
+	err = s.storageSvc.InsertApproval(ctx, storage.Approval{
+		MessageID: messageID,
+		Channel:   ch.name,
+		Reply:     reply,
+		Outcome:   string(result.Outcome),
+		FinalText: result.Text,
+		Operator:  result.Operator,
+		Latency:   result.Latency,
+	})
+	if err != nil {
+		slog.Warn("Failed to store approval", "error", err)
+	}
+
+	if result.Outcome == approval.OutcomeEdited && len(result.Text) > maxMessageLength {
+		return "", fmt.Errorf("edited response is too long (%d > %d)", len(result.Text), maxMessageLength)
+	}
+
+	return result.Text, nil
+}
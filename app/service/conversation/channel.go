@@ -0,0 +1,18 @@
+package conversation
+
+import (
+	"durkalive/app/config"
+)
+
+// Channel is the conversation state of a single twitch channel
+type Channel struct {
+	name    string
+	persona config.Persona
+	// shadow channels never post replies nor write to the database, everything goes to the transcript instead
+	shadow bool
+
+	decisionAgent *DecisionAgent
+	replyAgent    *ReplyAgent
+	state         *State
+	limiter       *replyLimiter
+}
@@ -1,15 +1,38 @@
 package conversation
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
 )
 
 type DecisionResponse struct {
-	NewSummary   string   `json:"new_summary"`
-	AddFacts     []string `json:"add_facts"`
-	RemoveFacts  []int    `json:"remove_facts"`
-	NeedResponse bool     `json:"need_response"`
+	NewSummary   string      `json:"new_summary"`
+	AddFacts     []FactInput `json:"add_facts"`
+	RemoveFacts  []int64     `json:"remove_facts"`
+	NeedResponse bool        `json:"need_response"`
+	// Confidence of the model in its need_response verdict, from 0 to 1, nil if the model left it out
+	Confidence *float64 `json:"confidence"`
+	Reason     string   `json:"reason"`
+}
+
+type FactInput struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	// TTLHours is the lifetime of the fact, zero means the fact never expires
+	TTLHours float64 `json:"ttl_hours"`
+}
+
+// UnmarshalJSON also accepts a plain string, which is treated as a channel-wide fact
+func (f *FactInput) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		f.Text = text
+		return nil
+	}
+
+	type plain FactInput
+	return json.Unmarshal(data, (*plain)(f))
 }
 
 type ReplyResponse struct {
@@ -21,4 +44,13 @@ type State struct {
 
 	chatHistory   ChatHistory
 	lastReplyTime time.Time
+
+	// streamStartedAt identifies the current stream, zero if unknown
+	streamStartedAt time.Time
+	// summary of the current stream beyond the chat history
+	summary string
+	// adBreak is set while twitch plays ads instead of the stream
+	adBreak bool
+	// events are the recent raids, follows, subscriptions and other stream events
+	events EventHistory
 }
@@ -4,10 +4,11 @@ import (
 	"context"
 	"durkalive/app/config"
 	"durkalive/app/service/memory"
+	"durkalive/app/service/streaminfo"
+	"durkalive/app/util/clock"
 	"encoding/json"
 	"fmt"
 	"strings"
-	"time"
 
 	_ "embed"
 
@@ -18,61 +19,79 @@ import (
 var decisionPromptTemplate string
 
 type DecisionAgent struct {
-	cfg       *config.Config
-	memorySvc *memory.Service
+	cfg           *config.Config
+	memorySvc     *memory.Service
+	streamInfoSvc *streaminfo.Service
 
 	client *openai.Client
 	model  string
 
-	state *State
+	channel string
+	persona config.Persona
+	state   *State
+	prompt  *promptTemplate
+	clock   clock.Clock
 }
 
 func NewDecisionAgent(
 	cfg *config.Config,
 	memorySvc *memory.Service,
+	streamInfoSvc *streaminfo.Service,
 	client *openai.Client,
 	model string,
+	channel string,
+	persona config.Persona,
 	state *State,
+	prompt *promptTemplate,
+	clk clock.Clock,
 ) *DecisionAgent {
 	return &DecisionAgent{
-		cfg:       cfg,
-		memorySvc: memorySvc,
-		client:    client,
-		model:     model,
-		state:     state,
+		cfg:           cfg,
+		memorySvc:     memorySvc,
+		streamInfoSvc: streamInfoSvc,
+		client:        client,
+		model:         model,
+		channel:       channel,
+		persona:       persona,
+		state:         state,
+		prompt:        prompt,
+		clock:         clk,
 	}
 }
 
-func (a *DecisionAgent) Call(ctx context.Context, username, text string) (*DecisionResponse, error) {
+func (a *DecisionAgent) Call(ctx context.Context, message chatMessage) (*DecisionResponse, agentTrace, error) {
+	var trace agentTrace
+
+	now := a.clock.Now()
+
 	a.state.mu.RLock()
 	lastReplyTime := a.state.lastReplyTime
-	factsStr := a.memorySvc.Format()
-	historyStr := a.state.chatHistory.format()
+	usernames := append(a.state.chatHistory.usernames(), message.Username)
+	factsQuery := a.state.chatHistory.retrievalQuery(message.Username, message.Text)
+	history := a.state.chatHistory.snapshot()
+	summary := a.state.summary
+	adBreak := a.state.adBreak
+	events := a.state.events.snapshot()
 	a.state.mu.RUnlock()
 
-	now := time.Now()
-
-	var lastReply string
-	if lastReplyTime.IsZero() {
-		lastReply = "Ты еще не писал сообщений в чат"
-	} else {
-		lastReply = fmt.Sprintf("Ты отвечал %d секунд назад", int(now.Sub(lastReplyTime).Seconds()))
-	}
-
-	templateValues := map[string]any{
-		"last_message": fmt.Sprintf("%s - %s: %s", formatTime(now), username, text),
-		"last_reply":   lastReply,
-		"now":          formatTime(now),
-		"channel":      a.cfg.Twitch.Channel,
-		"username":     a.cfg.Twitch.Username,
-		"chat_history": historyStr,
-		"facts":        factsStr,
-	}
-
-	prompt := decisionPromptTemplate
-	for key, value := range templateValues {
-		prompt = strings.ReplaceAll(prompt, "{"+key+"}", fmt.Sprint(value))
+	prompt, err := a.prompt.render(DecisionPromptData{
+		Channel:       a.channel,
+		Username:      a.cfg.Twitch.Username,
+		Persona:       a.persona,
+		Now:           now,
+		LastReplyTime: lastReplyTime,
+		LastMessage:   message,
+		History:       history,
+		Facts:         a.memorySvc.Relevant(ctx, a.channel, usernames, factsQuery),
+		Summary:       summary,
+		AdBreak:       adBreak,
+		Events:        events,
+		Stream:        newStreamContext(a.streamInfoSvc.Get(a.channel), now),
+	})
+	if err != nil {
+		return nil, trace, err
 	}
+	trace.Prompt = prompt
 
 	ctx, cancel := context.WithTimeout(ctx, maxReasonDuration)
 	defer cancel()
@@ -95,14 +114,16 @@ func (a *DecisionAgent) Call(ctx context.Context, username, text string) (*Decis
 		},
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create chat completion: %w", err)
+		return nil, trace, fmt.Errorf("failed to create chat completion: %w", err)
 	}
 
 	if len(aiResponse.Choices) == 0 {
-		return nil, fmt.Errorf("no chat completion found")
+		return nil, trace, fmt.Errorf("no chat completion found")
 	}
 
 	result := aiResponse.Choices[0].Message.Content
+	trace.Output = result
+
 	result = strings.Trim(result, "`")
 	result = strings.TrimSpace(result)
 	result = strings.TrimPrefix(result, "json")
@@ -110,8 +131,8 @@ func (a *DecisionAgent) Call(ctx context.Context, username, text string) (*Decis
 
 	var response DecisionResponse
 	if err = json.Unmarshal([]byte(result), &response); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		return nil, trace, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
-	return &response, nil
+	return &response, trace, nil
 }
@@ -0,0 +1,116 @@
+package conversation
+
+import (
+	"durkalive/app/service/queue"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const eventHistorySize = 10
+
+type streamEvent struct {
+	// Text describes the event for the prompts
+	Text      string
+	Timestamp time.Time
+}
+
+type EventHistory struct {
+	events []streamEvent
+}
+
+func (h *EventHistory) add(text string, timestamp time.Time) {
+	event := streamEvent{
+		Text:      text,
+		Timestamp: timestamp,
+	}
+
+	if len(h.events) >= eventHistorySize {
+		h.events = append(h.events[1:], event)
+	} else {
+		h.events = append(h.events, event)
+	}
+}
+
+// snapshot returns a copy of the events safe to use outside the state lock
+func (h *EventHistory) snapshot() []streamEvent {
+	result := make([]streamEvent, len(h.events))
+	copy(result, h.events)
+
+	return result
+}
+
+// ProcessEvent applies a stream event to the conversation state of the channel
+func (s *Service) ProcessEvent(channel string, event queue.EventType, details queue.EventDetails) error {
+	ch, err := s.channel(channel)
+	if err != nil {
+		return err
+	}
+
+	ch.state.mu.Lock()
+	defer ch.state.mu.Unlock()
+
+	switch event {
+	case queue.EventAdBreakStarted:
+		ch.state.adBreak = true
+	case queue.EventAdBreakEnded:
+		ch.state.adBreak = false
+	case queue.EventStreamOnline, queue.EventStreamOffline:
+		// the engine reports the stream lifecycle through BeginStream and EndStream
+	default:
+		text, err := describeEvent(event, details)
+		if err != nil {
+			return err
+		}
+
+		ch.state.events.add(text, s.clock.Now())
+	}
+
+	slog.Info("Stream event", "channel", ch.name, "event", event, "details", details)
+
+	return nil
+}
+
+func describeEvent(event queue.EventType, details queue.EventDetails) (string, error) {
+	username := details.Username
+	if username == "" {
+		username = "Аноним"
+	}
+
+	var text string
+
+	switch event {
+	case queue.EventChannelUpdate:
+		text = fmt.Sprintf("Стример сменил название стрима на \"%s\", категория: %s", details.Title, details.Category)
+	case queue.EventRaid:
+		text = fmt.Sprintf("%s зарейдил канал, зрителей: %d", username, details.Viewers)
+	case queue.EventFollow:
+		text = fmt.Sprintf("%s зафолловил канал", username)
+	case queue.EventSubscribe:
+		text = fmt.Sprintf("%s оформил подписку уровня %s", username, subscriptionLevel(details.Tier))
+		if details.Months > 0 {
+			text += fmt.Sprintf(", месяцев подписки: %d", details.Months)
+		}
+	case queue.EventCheer:
+		text = fmt.Sprintf("%s задонатил %d битс", username, details.Bits)
+	case queue.EventRedemption:
+		text = fmt.Sprintf("%s потратил баллы канала на награду \"%s\"", username, details.Reward)
+	default:
+		return "", fmt.Errorf("unknown event %q", event)
+	}
+
+	if details.Text != "" {
+		text += ": " + details.Text
+	}
+
+	return text, nil
+}
+
+// subscriptionLevel turns a twitch tier like 1000 into 1
+func subscriptionLevel(tier string) string {
+	if len(tier) == 4 {
+		return tier[:1]
+	}
+
+	return tier
+}
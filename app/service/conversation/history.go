@@ -1,30 +1,95 @@
 package conversation
 
 import (
+	"durkalive/app/service/queue"
+	"durkalive/app/service/storage"
 	"fmt"
 	"strings"
 	"time"
 )
 
-const messageHistorySize = 20
+const (
+	messageHistorySize   = 20
+	retrievalHistorySize = 5
+)
 
 type chatMessage struct {
 	Username  string
 	Text      string
 	Timestamp time.Time
+	// Source is empty for the bot replies
+	Source       queue.Source
+	Badges       queue.Badges
+	SubMonths    int
+	Bits         int
+	FirstMessage bool
+	// ReplyTo is the author of the message this one replies to
+	ReplyTo string
+	// ID is the twitch message id, empty for the speech and the bot replies
+	ID string
 }
 
-type ChatHistory struct {
-	messages []chatMessage
+func newChatMessage(msg queue.Message, timestamp time.Time) chatMessage {
+	result := chatMessage{
+		Username:     msg.Username,
+		Text:         msg.Text,
+		Timestamp:    timestamp,
+		Source:       msg.Source,
+		Badges:       msg.Badges,
+		SubMonths:    msg.SubMonths,
+		Bits:         msg.Bits,
+		FirstMessage: msg.FirstMessage,
+		ID:           msg.ID,
+	}
+
+	if msg.ReplyParent != nil {
+		result.ReplyTo = msg.ReplyParent.Username
+	}
+
+	return result
 }
 
-func (h *ChatHistory) add(username, text string) {
-	msg := chatMessage{
-		Username:  username,
-		Text:      text,
-		Timestamp: time.Now(),
+// roles lists the labels shown next to the author in the prompts
+func (m chatMessage) roles() []string {
+	var result []string
+
+	switch {
+	case m.Source == queue.SourceSpeech:
+		result = append(result, "голос")
+	case m.Badges.Broadcaster:
+		result = append(result, "стример")
+	case m.Badges.Moderator:
+		result = append(result, "модератор")
+	case m.Badges.VIP:
+		result = append(result, "vip")
+	}
+
+	if m.SubMonths > 0 {
+		result = append(result, fmt.Sprintf("подписчик %d мес.", m.SubMonths))
+	} else if m.Badges.Subscriber {
+		result = append(result, "подписчик")
 	}
 
+	if m.FirstMessage {
+		result = append(result, "первое сообщение")
+	}
+
+	if m.Bits > 0 {
+		result = append(result, fmt.Sprintf("%d битс", m.Bits))
+	}
+
+	if m.ReplyTo != "" {
+		result = append(result, "ответ @"+m.ReplyTo)
+	}
+
+	return result
+}
+
+type ChatHistory struct {
+	messages []chatMessage
+}
+
+func (h *ChatHistory) add(msg chatMessage) {
 	if len(h.messages) >= messageHistorySize {
 		h.messages = append(h.messages[1:], msg)
 	} else {
@@ -32,16 +97,56 @@ func (h *ChatHistory) add(username, text string) {
 	}
 }
 
-func (h *ChatHistory) format() string {
-	if len(h.messages) == 0 {
-		return "No recent messages"
+func (h *ChatHistory) restore(entries []storage.HistoryEntry) {
+	for _, entry := range entries {
+		h.messages = append(h.messages, chatMessage{
+			Username:  entry.Username,
+			Text:      entry.Text,
+			Timestamp: entry.Timestamp,
+			Source:    queue.Source(entry.Source),
+		})
 	}
 
-	var builder strings.Builder
+	if len(h.messages) > messageHistorySize {
+		h.messages = h.messages[len(h.messages)-messageHistorySize:]
+	}
+}
+
+// usernames returns the unique authors of the messages in history
+func (h *ChatHistory) usernames() []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(h.messages))
 
 	for _, msg := range h.messages {
-		builder.WriteString(fmt.Sprintf("%s - %s: %s\n", formatTime(msg.Timestamp), msg.Username, msg.Text))
+		if seen[msg.Username] {
+			continue
+		}
+
+		seen[msg.Username] = true
+		result = append(result, msg.Username)
+	}
+
+	return result
+}
+
+// retrievalQuery builds a text used to search for the facts relevant to the last message
+func (h *ChatHistory) retrievalQuery(username, text string) string {
+	var builder strings.Builder
+
+	start := max(0, len(h.messages)-retrievalHistorySize)
+	for _, msg := range h.messages[start:] {
+		builder.WriteString(fmt.Sprintf("%s: %s\n", msg.Username, msg.Text))
 	}
 
+	builder.WriteString(fmt.Sprintf("%s: %s", username, text))
+
 	return builder.String()
 }
+
+// snapshot returns a copy of the messages safe to use outside the state lock
+func (h *ChatHistory) snapshot() []chatMessage {
+	result := make([]chatMessage, len(h.messages))
+	copy(result, h.messages)
+
+	return result
+}
@@ -0,0 +1,146 @@
+package conversation
+
+import (
+	"durkalive/app/service/queue"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replyLimiter enforces the reply rate regardless of what the model decided
+type replyLimiter struct {
+	minGap       time.Duration
+	maxReplies   int
+	window       time.Duration
+	userCooldown time.Duration
+
+	mu       sync.Mutex
+	inFlight bool
+	// awaiting is the number of replies waiting for the operator, they don't hold the in-flight slot
+	awaiting int
+	sent     []time.Time
+	// lastUserReply is the time of the last reply to every viewer within the user cooldown
+	lastUserReply map[string]time.Time
+}
+
+func newReplyLimiter(minGap time.Duration, maxReplies int, window, userCooldown time.Duration) *replyLimiter {
+	return &replyLimiter{
+		minGap:        minGap,
+		maxReplies:    maxReplies,
+		window:        window,
+		userCooldown:  userCooldown,
+		lastUserReply: make(map[string]time.Time),
+	}
+}
+
+// viewer returns the username the user cooldown applies to, empty for the speech of the streamer
+func viewer(source queue.Source, username string) string {
+	if source != queue.SourceChat {
+		return ""
+	}
+
+	return strings.ToLower(username)
+}
+
+// acquire reserves the single in-flight reply slot, returning the reason if the reply is not allowed.
+// Overridden replies (mentions, thread replies to the bot) bypass the gap and window checks,
+// but not the cooldown of the viewer. An empty username is not subject to the cooldown.
+func (l *replyLimiter) acquire(now time.Time, username string, override bool) (bool, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight {
+		return false, "another reply is in flight"
+	}
+
+	l.dropOld(now)
+
+	if last, ok := l.lastUserReply[username]; ok && username != "" {
+		return false, fmt.Sprintf("less than %s since the last reply to %s at %s", l.userCooldown, username,
+			last.Format(time.TimeOnly))
+	}
+
+	if !override {
+		if len(l.sent) > 0 && now.Sub(l.sent[len(l.sent)-1]) < l.minGap {
+			return false, fmt.Sprintf("less than %s since the last reply", l.minGap)
+		}
+
+		if len(l.sent)+l.awaiting >= l.maxReplies {
+			if l.awaiting > 0 {
+				return false, fmt.Sprintf("%d replies in the last %s, %d awaiting approval", len(l.sent), l.window, l.awaiting)
+			}
+			return false, fmt.Sprintf("%d replies in the last %s", len(l.sent), l.window)
+		}
+	}
+
+	l.inFlight = true
+
+	return true, ""
+}
+
+// release frees the in-flight slot, sent tells whether the reply to the viewer was actually posted
+func (l *replyLimiter) release(now time.Time, username string, sent bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight = false
+
+	if sent {
+		l.recordSent(now, username)
+	}
+}
+
+// suspend frees the in-flight slot of a reply waiting for the operator,
+// the reply keeps counting toward the window cap until releaseSuspended
+func (l *replyLimiter) suspend() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight = false
+	l.awaiting++
+}
+
+// releaseSuspended is release for a suspended reply
+func (l *replyLimiter) releaseSuspended(now time.Time, username string, sent bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.awaiting--
+
+	if sent {
+		l.recordSent(now, username)
+	}
+}
+
+func (l *replyLimiter) recordSent(now time.Time, username string) {
+	l.sent = append(l.sent, now)
+
+	if username != "" {
+		l.lastUserReply[username] = now
+	}
+}
+
+// reset forgets the replies sent so far, a reply still in flight keeps its slot
+func (l *replyLimiter) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sent = nil
+	clear(l.lastUserReply)
+}
+
+func (l *replyLimiter) dropOld(now time.Time) {
+	i := 0
+	for i < len(l.sent) && now.Sub(l.sent[i]) >= l.window {
+		i++
+	}
+
+	l.sent = l.sent[i:]
+
+	for username, last := range l.lastUserReply {
+		if now.Sub(last) >= l.userCooldown {
+			delete(l.lastUserReply, username)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package conversation
+
+import (
+	"bytes"
+	"durkalive/app/config"
+	"durkalive/app/service/memory"
+	"durkalive/app/service/queue"
+	"durkalive/app/service/storage"
+	"durkalive/app/service/streaminfo"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// StreamContext describes the live stream, Live is false if the channel is offline or the info is unknown
+type StreamContext struct {
+	Live    bool
+	Title   string
+	Game    string
+	Tags    []string
+	Viewers int
+	Uptime  time.Duration
+}
+
+func newStreamContext(info streaminfo.Info, now time.Time) StreamContext {
+	if !info.Live() {
+		return StreamContext{}
+	}
+
+	return StreamContext{
+		Live:    true,
+		Title:   info.Title,
+		Game:    info.Game,
+		Tags:    info.Tags,
+		Viewers: info.Viewers,
+		Uptime:  now.Sub(info.StartedAt),
+	}
+}
+
+// DecisionPromptData is available to the decision prompt template
+type DecisionPromptData struct {
+	// Channel is the streamer login
+	Channel string
+	// Username is the bot login
+	Username string
+	Persona  config.Persona
+	Now      time.Time
+	// LastReplyTime is zero if the bot hasn't replied yet
+	LastReplyTime time.Time
+	LastMessage   chatMessage
+	History       []chatMessage
+	Facts         []storage.Fact
+	Summary       string
+	// AdBreak is set while viewers watch ads instead of the stream
+	AdBreak bool
+	// Events are the recent stream events like raids and subscriptions
+	Events []streamEvent
+	Stream StreamContext
+}
+
+// ReplyPromptData is available to the reply prompt template
+type ReplyPromptData struct {
+	// Channel is the streamer login
+	Channel string
+	// Username is the bot login
+	Username    string
+	Persona     config.Persona
+	Now         time.Time
+	LastMessage chatMessage
+	History     []chatMessage
+	Facts       []storage.Fact
+	Summary     string
+	// AdBreak is set while viewers watch ads instead of the stream
+	AdBreak bool
+	// Events are the recent stream events like raids and subscriptions
+	Events []streamEvent
+	Stream StreamContext
+}
+
+var promptFuncs = template.FuncMap{
+	"time":     formatTime,
+	"lifetime": memory.FormatLifetime,
+	"join":     strings.Join,
+	"uptime":   formatUptime,
+	"roles":    chatMessage.roles,
+	"seconds": func(d time.Duration) int {
+		return int(d.Seconds())
+	},
+}
+
+// promptTemplate is a text/template prompt, either embedded or loaded from disk.
+// Templates loaded from disk are reloaded when the file changes.
+type promptTemplate struct {
+	name string
+	path string
+	// sample is the data the template is validated against
+	sample any
+
+	mu      sync.Mutex
+	tmpl    *template.Template
+	modTime time.Time
+}
+
+func newPromptTemplate(name, path, embedded string, sample any) (*promptTemplate, error) {
+	t := &promptTemplate{
+		name:   name,
+		path:   path,
+		sample: sample,
+	}
+
+	if path == "" {
+		tmpl, err := t.parse(embedded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid embedded %s template: %w", name, err)
+		}
+
+		t.tmpl = tmpl
+
+		return t, nil
+	}
+
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// parse compiles the template and validates it by checking the fields of all the branches
+// and by executing against the sample data
+func (t *promptTemplate) parse(text string) (*template.Template, error) {
+	tmpl, err := template.New(t.name).Funcs(promptFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	if err = checkFields(tmpl, t.sample); err != nil {
+		return nil, fmt.Errorf("failed to validate template: %w", err)
+	}
+
+	if err = tmpl.Execute(io.Discard, t.sample); err != nil {
+		return nil, fmt.Errorf("failed to validate template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+func (t *promptTemplate) reload() error {
+	stat, err := os.Stat(t.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s template: %w", t.name, err)
+	}
+
+	if t.tmpl != nil && stat.ModTime().Equal(t.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s template: %w", t.name, err)
+	}
+
+	tmpl, err := t.parse(string(data))
+	if err != nil {
+		// don't retry until the file changes again
+		t.modTime = stat.ModTime()
+		return fmt.Errorf("invalid %s template %s: %w", t.name, t.path, err)
+	}
+
+	if t.tmpl != nil {
+		slog.Info("Reloaded prompt template", "name", t.name, "path", t.path)
+	}
+
+	t.tmpl = tmpl
+	t.modTime = stat.ModTime()
+
+	return nil
+}
+
+func (t *promptTemplate) render(data any) (string, error) {
+	t.mu.Lock()
+	if t.path != "" {
+		if err := t.reload(); err != nil {
+			slog.Error("Failed to reload prompt template, using the previous version", "error", err)
+		}
+	}
+	tmpl := t.tmpl
+	t.mu.Unlock()
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %w", t.name, err)
+	}
+
+	return buf.String(), nil
+}
+
+func sampleDecisionPromptData() DecisionPromptData {
+	return DecisionPromptData{
+		Channel:       "channel",
+		Username:      "bot",
+		Persona:       defaultPersona,
+		Now:           time.Now(),
+		LastReplyTime: time.Now(),
+		LastMessage:   samplePromptMessages()[0],
+		History:       samplePromptMessages(),
+		Facts:         sampleFacts(),
+		Summary:       "summary",
+		AdBreak:       true,
+		Events:        samplePromptEvents(),
+		Stream:        sampleStreamContext(),
+	}
+}
+
+func sampleReplyPromptData() ReplyPromptData {
+	return ReplyPromptData{
+		Channel:     "channel",
+		Username:    "bot",
+		Persona:     defaultPersona,
+		Now:         time.Now(),
+		LastMessage: samplePromptMessages()[0],
+		History:     samplePromptMessages(),
+		Facts:       sampleFacts(),
+		Summary:     "summary",
+		AdBreak:     true,
+		Events:      samplePromptEvents(),
+		Stream:      sampleStreamContext(),
+	}
+}
+
+func samplePromptMessages() []chatMessage {
+	return []chatMessage{{
+		Username:     "viewer",
+		Text:         "text",
+		Timestamp:    time.Now(),
+		Source:       queue.SourceChat,
+		Badges:       queue.Badges{Moderator: true, Subscriber: true},
+		SubMonths:    1,
+		Bits:         1,
+		FirstMessage: true,
+		ReplyTo:      "channel",
+	}}
+}
+
+func samplePromptEvents() []streamEvent {
+	return []streamEvent{{
+		Text:      "event",
+		Timestamp: time.Now(),
+	}}
+}
+
+func sampleStreamContext() StreamContext {
+	return StreamContext{
+		Live:    true,
+		Title:   "title",
+		Game:    "game",
+		Tags:    []string{"tag"},
+		Viewers: 1,
+		Uptime:  time.Hour,
+	}
+}
+
+func sampleFacts() []storage.Fact {
+	expiresAt := time.Now().Add(time.Hour)
+
+	return []storage.Fact{{
+		ID:        1,
+		Subject:   memory.SubjectStreamer,
+		Text:      "fact",
+		CreatedAt: time.Now(),
+		ExpiresAt: &expiresAt,
+	}}
+}
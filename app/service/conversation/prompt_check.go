@@ -0,0 +1,257 @@
+package conversation
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"text/template"
+	"text/template/parse"
+)
+
+// checkFields walks every branch of the template and reports the field references missing in the type of data.
+// Executing against the sample data only covers the branches the sample takes.
+func checkFields(tmpl *template.Template, data any) error {
+	if tmpl.Tree == nil {
+		return nil
+	}
+
+	c := &fieldChecker{
+		tmpl: tmpl,
+		vars: map[string]reflect.Type{"$": reflect.TypeOf(data)},
+	}
+
+	return c.walk(tmpl.Tree.Root, reflect.TypeOf(data))
+}
+
+// fieldChecker tracks the type of dot and of the variables, a nil type is unknown and is not checked
+type fieldChecker struct {
+	tmpl *template.Template
+	vars map[string]reflect.Type
+}
+
+func (c *fieldChecker) walk(node parse.Node, dot reflect.Type) error {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, child := range node.Nodes {
+			if err := c.walk(child, dot); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		_, err := c.pipe(node.Pipe, dot)
+		return err
+	case *parse.IfNode:
+		return c.branch(&node.BranchNode, dot, false)
+	case *parse.WithNode:
+		return c.branch(&node.BranchNode, dot, true)
+	case *parse.RangeNode:
+		vars := maps.Clone(c.vars)
+		defer func() {
+			c.vars = vars
+		}()
+
+		t, err := c.pipe(node.Pipe, dot)
+		if err != nil {
+			return err
+		}
+
+		key, elem := rangeTypes(t)
+		switch len(node.Pipe.Decl) {
+		case 1:
+			c.vars[node.Pipe.Decl[0].Ident[0]] = elem
+		case 2:
+			c.vars[node.Pipe.Decl[0].Ident[0]] = key
+			c.vars[node.Pipe.Decl[1].Ident[0]] = elem
+		}
+
+		if err = c.walk(node.List, elem); err != nil {
+			return err
+		}
+		return c.walk(node.ElseList, dot)
+	case *parse.TemplateNode:
+		if node.Pipe != nil {
+			_, err := c.pipe(node.Pipe, dot)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// branch checks an if or a with, the body of a with runs with dot set to the pipeline value
+func (c *fieldChecker) branch(node *parse.BranchNode, dot reflect.Type, with bool) error {
+	vars := maps.Clone(c.vars)
+	defer func() {
+		c.vars = vars
+	}()
+
+	t, err := c.pipe(node.Pipe, dot)
+	if err != nil {
+		return err
+	}
+
+	body := dot
+	if with {
+		body = t
+	}
+
+	if err = c.walk(node.List, body); err != nil {
+		return err
+	}
+	return c.walk(node.ElseList, dot)
+}
+
+func (c *fieldChecker) pipe(pipe *parse.PipeNode, dot reflect.Type) (reflect.Type, error) {
+	var result reflect.Type
+	for _, cmd := range pipe.Cmds {
+		t, err := c.command(cmd, dot)
+		if err != nil {
+			return nil, err
+		}
+		result = t
+	}
+
+	for _, variable := range pipe.Decl {
+		c.vars[variable.Ident[0]] = result
+	}
+
+	return result, nil
+}
+
+func (c *fieldChecker) command(cmd *parse.CommandNode, dot reflect.Type) (reflect.Type, error) {
+	for _, arg := range cmd.Args[1:] {
+		if _, err := c.arg(arg, dot); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.arg(cmd.Args[0], dot)
+}
+
+func (c *fieldChecker) arg(node parse.Node, dot reflect.Type) (reflect.Type, error) {
+	switch node := node.(type) {
+	case *parse.DotNode:
+		return dot, nil
+	case *parse.FieldNode:
+		return c.fields(node, dot, node.Ident)
+	case *parse.VariableNode:
+		return c.fields(node, c.vars[node.Ident[0]], node.Ident[1:])
+	case *parse.ChainNode:
+		t, err := c.arg(node.Node, dot)
+		if err != nil {
+			return nil, err
+		}
+		return c.fields(node, t, node.Field)
+	case *parse.PipeNode:
+		return c.pipe(node, dot)
+	case *parse.IdentifierNode:
+		return funcType(node.Ident), nil
+	case *parse.StringNode:
+		return reflect.TypeFor[string](), nil
+	case *parse.BoolNode:
+		return reflect.TypeFor[bool](), nil
+	}
+
+	return nil, nil
+}
+
+func (c *fieldChecker) fields(node parse.Node, t reflect.Type, names []string) (reflect.Type, error) {
+	for _, name := range names {
+		if t == nil {
+			return nil, nil
+		}
+
+		next, ok := fieldType(t, name)
+		if !ok {
+			location, _ := c.tmpl.ErrorContext(node)
+			return nil, fmt.Errorf("%s: can't evaluate field %s in type %s", location, name, t)
+		}
+		t = next
+	}
+
+	return t, nil
+}
+
+// fieldType resolves a field, a method or a map key of t, the type is nil if it is unknown
+func fieldType(t reflect.Type, name string) (reflect.Type, bool) {
+	if method, ok := t.MethodByName(name); ok {
+		return resultType(method.Type), true
+	}
+	if t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface {
+		if method, ok := reflect.PointerTo(t).MethodByName(name); ok {
+			return resultType(method.Type), true
+		}
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if field, ok := t.FieldByName(name); ok && field.IsExported() {
+			return field.Type, true
+		}
+	case reflect.Map:
+		if t.Key().Kind() == reflect.String {
+			return t.Elem(), true
+		}
+	case reflect.Interface:
+		return nil, true
+	}
+
+	return nil, false
+}
+
+// funcType is the result type of a template function, nil for the builtins returning any of their arguments
+func funcType(name string) reflect.Type {
+	if f, ok := promptFuncs[name]; ok {
+		return resultType(reflect.TypeOf(f))
+	}
+
+	switch name {
+	case "not", "eq", "ne", "lt", "le", "gt", "ge":
+		return reflect.TypeFor[bool]()
+	case "len":
+		return reflect.TypeFor[int]()
+	case "print", "printf", "println", "html", "js", "urlquery":
+		return reflect.TypeFor[string]()
+	}
+
+	return nil
+}
+
+func resultType(f reflect.Type) reflect.Type {
+	if f.NumOut() == 0 {
+		return nil
+	}
+
+	return f.Out(0)
+}
+
+// rangeTypes returns the key and the element types of a range over t
+func rangeTypes(t reflect.Type) (reflect.Type, reflect.Type) {
+	if t == nil {
+		return nil, nil
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return reflect.TypeFor[int](), t.Elem()
+	case reflect.Map:
+		return t.Key(), t.Elem()
+	case reflect.Chan:
+		return t.Elem(), t.Elem()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return t, t
+	}
+
+	return nil, nil
+}
@@ -4,9 +4,10 @@ import (
 	"context"
 	"durkalive/app/config"
 	"durkalive/app/service/memory"
+	"durkalive/app/service/streaminfo"
+	"durkalive/app/util/clock"
 	"fmt"
 	"strings"
-	"time"
 
 	_ "embed"
 
@@ -17,50 +18,77 @@ import (
 var replyPromptTemplate string
 
 type ReplyAgent struct {
-	cfg       *config.Config
-	memorySvc *memory.Service
+	cfg           *config.Config
+	memorySvc     *memory.Service
+	streamInfoSvc *streaminfo.Service
 
 	client *openai.Client
 	model  string
 
-	state *State
+	channel string
+	persona config.Persona
+	state   *State
+	prompt  *promptTemplate
+	clock   clock.Clock
 }
 
 func NewReplyAgent(
 	cfg *config.Config,
 	memorySvc *memory.Service,
+	streamInfoSvc *streaminfo.Service,
 	client *openai.Client,
 	model string,
+	channel string,
+	persona config.Persona,
 	state *State,
+	prompt *promptTemplate,
+	clk clock.Clock,
 ) *ReplyAgent {
 	return &ReplyAgent{
-		cfg:       cfg,
-		memorySvc: memorySvc,
-		client:    client,
-		model:     model,
-		state:     state,
+		cfg:           cfg,
+		memorySvc:     memorySvc,
+		streamInfoSvc: streamInfoSvc,
+		client:        client,
+		model:         model,
+		channel:       channel,
+		persona:       persona,
+		state:         state,
+		prompt:        prompt,
+		clock:         clk,
 	}
 }
 
-func (a *ReplyAgent) Call(ctx context.Context, username, text string) (string, error) {
+func (a *ReplyAgent) Call(ctx context.Context, message chatMessage) (string, agentTrace, error) {
+	var trace agentTrace
+
+	now := a.clock.Now()
+
 	a.state.mu.RLock()
-	factsStr := a.memorySvc.Format()
-	historyStr := a.state.chatHistory.format()
+	usernames := append(a.state.chatHistory.usernames(), message.Username)
+	factsQuery := a.state.chatHistory.retrievalQuery(message.Username, message.Text)
+	history := a.state.chatHistory.snapshot()
+	summary := a.state.summary
+	adBreak := a.state.adBreak
+	events := a.state.events.snapshot()
 	a.state.mu.RUnlock()
 
-	now := time.Now()
-	templateValues := map[string]any{
-		"last_message": fmt.Sprintf("%s - %s: %s", formatTime(now), username, text),
-		"channel":      a.cfg.Twitch.Channel,
-		"username":     a.cfg.Twitch.Username,
-		"chat_history": historyStr,
-		"facts":        factsStr,
-	}
-
-	prompt := replyPromptTemplate
-	for key, value := range templateValues {
-		prompt = strings.ReplaceAll(prompt, "{"+key+"}", fmt.Sprint(value))
+	prompt, err := a.prompt.render(ReplyPromptData{
+		Channel:     a.channel,
+		Username:    a.cfg.Twitch.Username,
+		Persona:     a.persona,
+		Now:         now,
+		LastMessage: message,
+		History:     history,
+		Facts:       a.memorySvc.Relevant(ctx, a.channel, usernames, factsQuery),
+		Summary:     summary,
+		AdBreak:     adBreak,
+		Events:      events,
+		Stream:      newStreamContext(a.streamInfoSvc.Get(a.channel), now),
+	})
+	if err != nil {
+		return "", trace, err
 	}
+	trace.Prompt = prompt
 
 	ctx, cancel := context.WithTimeout(ctx, maxReasonDuration)
 	defer cancel()
@@ -80,13 +108,15 @@ func (a *ReplyAgent) Call(ctx context.Context, username, text string) (string, e
 		},
 	)
 	if err != nil {
-		return "", fmt.Errorf("failed to create chat completion: %w", err)
+		return "", trace, fmt.Errorf("failed to create chat completion: %w", err)
 	}
 
 	if len(aiResponse.Choices) == 0 {
-		return "", fmt.Errorf("no chat completion found")
+		return "", trace, fmt.Errorf("no chat completion found")
 	}
 
 	result := aiResponse.Choices[0].Message.Content
-	return strings.TrimSpace(result), nil
+	trace.Output = result
+
+	return strings.TrimSpace(result), trace, nil
 }
@@ -2,13 +2,21 @@ package conversation
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
 	"time"
 
 	"durkalive/app/client/twitch"
 	"durkalive/app/config"
+	"durkalive/app/service/approval"
 	"durkalive/app/service/memory"
+	"durkalive/app/service/queue"
+	"durkalive/app/service/storage"
+	"durkalive/app/service/streaminfo"
+	"durkalive/app/util/clock"
 
 	_ "embed"
 
@@ -16,77 +24,297 @@ import (
 )
 
 const (
-	minConfidence     = 0.8
 	maxReasonDuration = 30 * time.Second
 	maxMessageLength  = 500
 )
 
+// ReplyListener is notified about every reply the bot sends
+type ReplyListener func(channel, username, text, reply string, at time.Time)
+
 type Service struct {
 	cfg          *config.Config
 	twitchClient *twitch.Client
 	memorySvc    *memory.Service
+	storageSvc   *storage.Service
+	approvalSvc  *approval.Service
+	clock        clock.Clock
+	// transcript is nil unless a channel runs in shadow mode
+	transcript *transcript
+
+	channels map[string]*Channel
+
+	// replies tracks the reply goroutines
+	replies sync.WaitGroup
 
-	decisionAgent *DecisionAgent
-	replyAgent    *ReplyAgent
-	state         *State
+	listenerMu    sync.RWMutex
+	replyListener ReplyListener
 }
 
 func New(di *do.Injector) (*Service, error) {
+	ctx := do.MustInvoke[context.Context](di)
 	cfg := do.MustInvoke[*config.Config](di)
 	memorySvc := do.MustInvoke[*memory.Service](di)
+	storageSvc := do.MustInvoke[*storage.Service](di)
+	streamInfoSvc := do.MustInvoke[*streaminfo.Service](di)
+	clk := do.MustInvoke[clock.Clock](di)
+
+	decisionPrompt, err := newPromptTemplate("decision", cfg.Prompts.Decision, decisionPromptTemplate,
+		sampleDecisionPromptData())
+	if err != nil {
+		return nil, err
+	}
 
-	var state State
+	replyPrompt, err := newPromptTemplate("reply", cfg.Prompts.Reply, replyPromptTemplate, sampleReplyPromptData())
+	if err != nil {
+		return nil, err
+	}
 
-	decisionAgent := NewDecisionAgent(cfg, memorySvc, createClient(cfg.OpenAI.Decision), cfg.OpenAI.Decision.Model,
-		&state)
-	replyAgent := NewReplyAgent(cfg, memorySvc, createClient(cfg.OpenAI.Reply), cfg.OpenAI.Reply.Model, &state)
+	decisionClient := createClient(cfg.OpenAI.Decision)
+	replyClient := createClient(cfg.OpenAI.Reply)
 
 	s := &Service{
-		cfg:           cfg,
-		twitchClient:  do.MustInvoke[*twitch.Client](di),
-		memorySvc:     memorySvc,
-		decisionAgent: decisionAgent,
-		replyAgent:    replyAgent,
-		state:         &state,
+		cfg:          cfg,
+		twitchClient: do.MustInvoke[*twitch.Client](di),
+		memorySvc:    memorySvc,
+		storageSvc:   storageSvc,
+		approvalSvc:  do.MustInvoke[*approval.Service](di),
+		clock:        clk,
+		channels:     make(map[string]*Channel, len(cfg.Twitch.Channels)),
+	}
+
+	if slices.ContainsFunc(cfg.Twitch.Channels, func(channel config.Channel) bool { return channel.Shadow }) {
+		if s.transcript, err = newTranscript(cfg.Conversation.TranscriptDir, clk.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, channelCfg := range cfg.Twitch.Channels {
+		persona, err := resolvePersona(cfg, channelCfg.Persona)
+		if err != nil {
+			return nil, err
+		}
+
+		var state State
+
+		history, err := storageSvc.RecentHistory(ctx, channelCfg.Name, messageHistorySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore chat history of %s: %w", channelCfg.Name, err)
+		}
+		state.chatHistory.restore(history)
+
+		s.channels[channelCfg.Name] = &Channel{
+			name:    channelCfg.Name,
+			persona: persona,
+			shadow:  channelCfg.Shadow,
+			decisionAgent: NewDecisionAgent(cfg, memorySvc, streamInfoSvc, decisionClient, cfg.OpenAI.Decision.Model,
+				channelCfg.Name, persona, &state, decisionPrompt, clk),
+			replyAgent: NewReplyAgent(cfg, memorySvc, streamInfoSvc, replyClient, cfg.OpenAI.Reply.Model,
+				channelCfg.Name, persona, &state, replyPrompt, clk),
+			state: &state,
+			limiter: newReplyLimiter(
+				*cfg.Conversation.MinReplyGap,
+				cfg.Conversation.MaxReplies,
+				cfg.Conversation.ReplyWindow,
+				cfg.Conversation.UserCooldown,
+			),
+		}
 	}
 
 	return s, nil
 }
 
-func (s *Service) ProcessMessage(ctx context.Context, username, text string) error {
+func (s *Service) SetReplyListener(listener ReplyListener) {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+
+	s.replyListener = listener
+}
+
+// WaitReplies blocks until the replies being generated are done
+func (s *Service) WaitReplies() {
+	s.replies.Wait()
+}
+
+func (s *Service) channel(name string) (*Channel, error) {
+	ch, ok := s.channels[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown channel %q", name)
+	}
+
+	return ch, nil
+}
+
+func (s *Service) ProcessMessage(ctx context.Context, channel string, msg queue.Message) (err error) {
+	ch, err := s.channel(channel)
+	if err != nil {
+		return err
+	}
+
+	message := newChatMessage(msg, s.clock.Now())
+
+	record := transcriptRecord{
+		Type:     recordDecision,
+		Time:     message.Timestamp,
+		Channel:  ch.name,
+		Source:   msg.Source,
+		Username: msg.Username,
+		Text:     msg.Text,
+	}
+	if ch.shadow {
+		defer func() {
+			if err != nil {
+				record.Error = err.Error()
+			}
+			s.transcript.write(record)
+		}()
+	}
+
 	defer func() {
-		s.state.mu.Lock()
-		s.state.chatHistory.add(username, text)
-		s.state.mu.Unlock()
+		ch.state.mu.Lock()
+		ch.state.chatHistory.add(message)
+		ch.state.mu.Unlock()
 	}()
 
-	result, err := s.decisionAgent.Call(ctx, username, text)
+	// shadow channels write to the transcript only, the database and the memory stay as they are
+	var messageID int64
+	if !ch.shadow {
+		messageID, err = s.storageSvc.InsertMessage(ctx, storage.Message{
+			Channel:  ch.name,
+			Username: msg.Username,
+			Text:     msg.Text,
+			Source:   string(msg.Source),
+			TwitchID: msg.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("storageSvc.InsertMessage: %w", err)
+		}
+		record.MessageID = messageID
+	}
+
+	result, trace, err := ch.decisionAgent.Call(ctx, message)
+	record.Decision = &trace
 	if err != nil {
 		return fmt.Errorf("decisionAgent.Call: %w", err)
 	}
+	record.Parsed = result
+
+	// the override is decided in code, the model verdict alone must not lift the rate limits
+	override := isAddressed(msg, s.cfg.Twitch.Username, ch.persona)
+	minConfidence := *s.cfg.Conversation.MinConfidence
+	respond := result.NeedResponse
+
+	// a missing confidence fails the gate, otherwise the model would bypass it by leaving the field out
+	var skipReason string
+	switch {
+	case !respond, override:
+	case result.Confidence == nil:
+		respond, skipReason = false, "confidence is missing"
+	case *result.Confidence < minConfidence:
+		respond, skipReason = false, fmt.Sprintf("confidence %.2f is below %.2f", *result.Confidence, minConfidence)
+	}
+
+	if respond {
+		respond, skipReason = ch.limiter.acquire(s.clock.Now(), viewer(msg.Source, msg.Username), override)
+	}
+	record.Respond = respond
+	record.SkipReason = skipReason
+
+	// the reply goroutine takes over the limiter slot, any early return must free it
+	replyStarted := false
+	defer func() {
+		if respond && !replyStarted {
+			ch.limiter.release(s.clock.Now(), "", false)
+		}
+	}()
+
+	var confidence any = "unset"
+	if result.Confidence != nil {
+		confidence = *result.Confidence
+	}
+
+	slog.Info("Decision made",
+		"channel", ch.name,
+		"username", msg.Username,
+		"source", msg.Source,
+		"need_response", result.NeedResponse,
+		"confidence", confidence,
+		"reason", result.Reason,
+		"respond", respond,
+		"skip_reason", skipReason)
+
+	if !ch.shadow {
+		err = s.storageSvc.InsertDecision(ctx, storage.Decision{
+			MessageID:    messageID,
+			NeedResponse: result.NeedResponse,
+			Confidence:   result.Confidence,
+			Reason:       result.Reason,
+			Respond:      respond,
+			SkipReason:   skipReason,
+			Result:       result,
+		})
+		if err != nil {
+			return fmt.Errorf("storageSvc.InsertDecision: %w", err)
+		}
+	}
 
-	for i, value := range result.RemoveFacts {
-		result.RemoveFacts[i] = value - 1
+	if err = s.updateSummary(ctx, ch, result.NewSummary); err != nil {
+		slog.Warn("Failed to update summary", "error", err)
 	}
 
-	if err = s.memorySvc.RemoveFacts(result.RemoveFacts); err != nil {
+	var unknownIDs []int64
+	if ch.shadow {
+		unknownIDs = s.memorySvc.UnknownFacts(ch.name, result.RemoveFacts)
+	} else if unknownIDs, err = s.memorySvc.RemoveFacts(ctx, ch.name, result.RemoveFacts); err != nil {
 		return fmt.Errorf("memorySvc.RemoveFacts: %w", err)
 	}
+	if len(unknownIDs) > 0 {
+		slog.Warn("Skipped removal of unknown facts", "ids", unknownIDs)
+	}
+	record.UnknownFactIDs = unknownIDs
+	for _, id := range result.RemoveFacts {
+		if !slices.Contains(unknownIDs, id) {
+			record.RemovedFacts = append(record.RemovedFacts, id)
+		}
+	}
+
+	newFacts := make([]memory.NewFact, 0, len(result.AddFacts))
+	for _, fact := range result.AddFacts {
+		newFacts = append(newFacts, memory.NewFact{
+			Subject: fact.Subject,
+			Text:    fact.Text,
+			TTL:     time.Duration(fact.TTLHours * float64(time.Hour)),
+		})
+	}
+
+	provenance := memory.Provenance{
+		MessageID: messageID,
+		Username:  msg.Username,
+		Model:     s.cfg.OpenAI.Decision.Model,
+	}
 
-	if err = s.memorySvc.AddFacts(result.AddFacts); err != nil {
-		return fmt.Errorf("memorySvc.AddFacts: %w", err)
+	if !ch.shadow {
+		if err = s.memorySvc.AddFacts(ctx, ch.name, newFacts, provenance); err != nil {
+			return fmt.Errorf("memorySvc.AddFacts: %w", err)
+		}
 	}
+	record.AddedFacts = result.AddFacts
 
-	if !result.NeedResponse {
+	if !respond {
 		slog.Debug("Response is not required")
 		return nil
 	}
 
+	replyStarted = true
+
+	s.replies.Add(1)
 	go func() {
-		if err := s.generateReply(ctx, username, text); err != nil {
+		defer s.replies.Done()
+
+		if err := s.generateReply(ctx, ch, messageID, message); err != nil {
 			slog.Error("Failed to generate reply",
-				"username", username,
-				"text", text,
+				"channel", ch.name,
+				"username", msg.Username,
+				"text", msg.Text,
 				"error", err,
 			)
 		}
@@ -95,47 +323,155 @@ func (s *Service) ProcessMessage(ctx context.Context, username, text string) err
 	return nil
 }
 
-func (s *Service) generateReply(ctx context.Context, username, text string) error {
-	replyText, err := s.replyAgent.Call(ctx, username, text)
+func (s *Service) generateReply(ctx context.Context, ch *Channel, messageID int64, message chatMessage) (err error) {
+	sent, suspended := false, false
+	username := viewer(message.Source, message.Username)
+	defer func() {
+		if suspended {
+			ch.limiter.releaseSuspended(s.clock.Now(), username, sent)
+		} else {
+			ch.limiter.release(s.clock.Now(), username, sent)
+		}
+	}()
+
+	record := transcriptRecord{
+		Type:      recordReply,
+		Channel:   ch.name,
+		MessageID: messageID,
+		Source:    message.Source,
+		Username:  message.Username,
+		Text:      message.Text,
+		Respond:   true,
+	}
+	if ch.shadow {
+		defer func() {
+			record.Time = s.clock.Now()
+			if err != nil {
+				record.Error = err.Error()
+			}
+			s.transcript.write(record)
+		}()
+	}
+
+	replyText, trace, err := ch.replyAgent.Call(ctx, message)
+	record.Reply = &trace
 	if err != nil {
 		return fmt.Errorf("replyAgent.Call: %w", err)
 	}
+	record.Response = replyText
 
 	if len(replyText) > maxMessageLength {
 		return fmt.Errorf("response is too long (%d > %d)", len(replyText), maxMessageLength)
 	}
 
-	if err = s.sendMessage(replyText); err != nil {
+	if s.needsApproval(ch) {
+		// the operator may take up to approval.timeout to answer, other replies must not wait for it
+		ch.limiter.suspend()
+		suspended = true
+
+		if replyText, err = s.approve(ctx, ch, messageID, message.Username, message.Text, replyText); err != nil {
+			return err
+		}
+		if replyText == "" {
+			return nil
+		}
+	}
+
+	if replyText, err = s.sendMessage(ch, message, replyText); err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
+	sent = true
+
+	now := s.clock.Now()
+
+	s.listenerMu.RLock()
+	listener := s.replyListener
+	s.listenerMu.RUnlock()
+
+	if listener != nil {
+		listener(ch.name, message.Username, message.Text, replyText, now)
+	}
+
+	if !ch.shadow {
+		if err = s.storageSvc.InsertReply(ctx, ch.name, messageID, s.cfg.Twitch.Username, replyText); err != nil {
+			slog.Warn("Failed to store reply", "error", err)
+		}
+	}
 
-	s.state.mu.Lock()
-	s.state.chatHistory.add(s.cfg.Twitch.Username, replyText)
-	s.state.lastReplyTime = time.Now()
-	s.state.mu.Unlock()
+	ch.state.mu.Lock()
+	ch.state.chatHistory.add(chatMessage{
+		Username:  s.cfg.Twitch.Username,
+		Text:      replyText,
+		Timestamp: now,
+	})
+	ch.state.lastReplyTime = now
+	ch.state.mu.Unlock()
 
 	return nil
 }
 
-func (s *Service) sendMessage(text string) error {
+// sendMessage posts the reply to the message, returns the text as it was posted
+func (s *Service) sendMessage(ch *Channel, message chatMessage, text string) (string, error) {
+	parentID := ""
+	if s.cfg.Twitch.ThreadReplies && message.Source == queue.SourceChat {
+		parentID = message.ID
+	}
+	if parentID == "" {
+		text = mention(message, text)
+	}
+
+	if ch.shadow {
+		slog.Info("Replied to message (shadow mode)",
+			"channel", ch.name,
+			"reply_to", parentID,
+			"text", text)
+		return text, nil
+	}
+
 	if s.cfg.Twitch.DisableNotifications {
 		slog.Info("Replied to message (notifications disabled)",
+			"channel", ch.name,
+			"reply_to", parentID,
 			"text", text,
 			"telegram", true)
-		return nil
+		return text, nil
+	}
+
+	if parentID != "" {
+		err := s.twitchClient.SendReply(ch.name, text, parentID)
+		switch {
+		case err == nil:
+			slog.Info("Replied to message",
+				"channel", ch.name,
+				"reply_to", parentID,
+				"text", text,
+				"telegram", true)
+			return text, nil
+		case !errors.Is(err, twitch.ErrInvalidReplyParent):
+			// the reply may be posted despite a transport error, posting it again would double it
+			return "", fmt.Errorf("failed to send reply to twitch: %w", err)
+		}
+
+		// the parent message may be deleted by the moderators in the meantime
+		slog.Warn("Failed to reply in thread, posting a mention instead",
+			"channel", ch.name,
+			"reply_to", parentID,
+			"error", err)
+		text = mention(message, text)
 	}
 
-	if err := s.twitchClient.SendMessage(s.cfg.Twitch.Channel, text); err != nil {
-		return fmt.Errorf("failed to send message to twitch: %w", err)
+	if err := s.twitchClient.SendMessage(ch.name, text); err != nil {
+		return "", fmt.Errorf("failed to send message to twitch: %w", err)
 	}
 
 	slog.Info("Replied to message",
+		"channel", ch.name,
 		"text", text,
 		"telegram", true)
 
-	return nil
+	return text, nil
 }
 
 func (s *Service) Close() error {
-	return nil
+	return s.transcript.close()
 }
@@ -0,0 +1,103 @@
+package conversation
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// BeginStream switches the channel conversation to the stream started at the given time, resets the per-stream
+// state and restores the summary. Zero time means the stream is unknown, the summary is then kept only in memory.
+// A summary that fails to load is logged, the stream then starts without it.
+func (s *Service) BeginStream(ctx context.Context, channel string, streamStartedAt time.Time) error {
+	ch, err := s.channel(channel)
+	if err != nil {
+		return err
+	}
+
+	if s.isCurrentStream(ch, streamStartedAt) {
+		return nil
+	}
+
+	var summary string
+	if !streamStartedAt.IsZero() {
+		summary, err = s.storageSvc.GetSummary(ctx, ch.name, streamStartedAt)
+		if err != nil {
+			slog.Error("Failed to restore stream summary", "channel", ch.name, "error", err)
+		}
+	}
+
+	// the summary is loaded without the lock, a concurrent call may have switched the stream already
+	ch.state.mu.Lock()
+	if ch.state.streamStartedAt.Equal(streamStartedAt) {
+		ch.state.mu.Unlock()
+		return nil
+	}
+
+	ch.state.streamStartedAt = streamStartedAt
+	ch.state.summary = summary
+	ch.state.lastReplyTime = time.Time{}
+	ch.state.adBreak = false
+	ch.state.events = EventHistory{}
+	ch.state.events.add("Стрим начался", s.clock.Now())
+	ch.state.mu.Unlock()
+
+	ch.limiter.reset()
+
+	slog.Info("Conversation switched to stream",
+		"channel", ch.name,
+		"started_at", streamStartedAt,
+		"has_summary", summary != "")
+
+	return nil
+}
+
+func (s *Service) isCurrentStream(ch *Channel, streamStartedAt time.Time) bool {
+	ch.state.mu.RLock()
+	defer ch.state.mu.RUnlock()
+
+	return ch.state.streamStartedAt.Equal(streamStartedAt)
+}
+
+// EndStream marks the stream of the channel as finished, the next BeginStream starts from a clean state
+func (s *Service) EndStream(channel string) {
+	ch, err := s.channel(channel)
+	if err != nil {
+		slog.Warn("Could not end stream", "error", err)
+		return
+	}
+
+	ch.state.mu.Lock()
+	streamStartedAt := ch.state.streamStartedAt
+	ch.state.streamStartedAt = time.Time{}
+	ch.state.adBreak = false
+	ch.state.events.add("Стрим закончился", s.clock.Now())
+	ch.state.mu.Unlock()
+
+	slog.Info("Stream ended", "channel", ch.name, "started_at", streamStartedAt)
+}
+
+func (s *Service) updateSummary(ctx context.Context, ch *Channel, summary string) error {
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return nil
+	}
+
+	ch.state.mu.Lock()
+	ch.state.summary = summary
+	streamStartedAt := ch.state.streamStartedAt
+	ch.state.mu.Unlock()
+
+	// shadow channels keep their summary in memory only
+	if streamStartedAt.IsZero() || ch.shadow {
+		return nil
+	}
+
+	if err := s.storageSvc.SaveSummary(ctx, ch.name, streamStartedAt, summary); err != nil {
+		return fmt.Errorf("storageSvc.SaveSummary: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package conversation
+
+import (
+	"durkalive/app/service/queue"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// agentTrace is the raw exchange with the model
+type agentTrace struct {
+	Prompt string `json:"prompt,omitempty"`
+	Output string `json:"output,omitempty"`
+}
+
+const (
+	recordDecision = "decision"
+	recordReply    = "reply"
+)
+
+// transcriptRecord is a line of the shadow mode session file
+type transcriptRecord struct {
+	Type      string       `json:"type"`
+	Time      time.Time    `json:"time"`
+	Channel   string       `json:"channel"`
+	MessageID int64        `json:"message_id,omitempty"`
+	Source    queue.Source `json:"source,omitempty"`
+	Username  string       `json:"username"`
+	Text      string       `json:"text"`
+
+	Decision   *agentTrace       `json:"decision,omitempty"`
+	Parsed     *DecisionResponse `json:"parsed,omitempty"`
+	Respond    bool              `json:"respond"`
+	SkipReason string            `json:"skip_reason,omitempty"`
+
+	// the fact mutations are the ones the decision asked for, shadow channels never apply them
+	AddedFacts     []FactInput `json:"added_facts,omitempty"`
+	RemovedFacts   []int64     `json:"removed_facts,omitempty"`
+	UnknownFactIDs []int64     `json:"unknown_fact_ids,omitempty"`
+
+	Reply *agentTrace `json:"reply,omitempty"`
+	// Response is the reply that would have been posted
+	Response string `json:"response,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// transcript writes the session file of the channels running in shadow mode
+type transcript struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func newTranscript(dir string, now time.Time) (*transcript, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create transcript dir: %w", err)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("session-%s.jsonl", now.Format("20060102-150405")))
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transcript: %w", err)
+	}
+
+	slog.Info("Writing shadow mode transcript", "path", path)
+
+	return &transcript{
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}, nil
+}
+
+// write is a no-op on a nil transcript
+func (t *transcript) write(record transcriptRecord) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.encoder.Encode(record); err != nil {
+		slog.Error("Failed to write transcript", "error", err)
+	}
+}
+
+func (t *transcript) close() error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.file.Close()
+}
@@ -2,8 +2,13 @@ package conversation
 
 import (
 	"durkalive/app/config"
+	"durkalive/app/service/queue"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/sashabaranov/go-openai"
 )
@@ -26,3 +31,104 @@ func formatTime(t time.Time) string {
 
 	return t.Format("15:04:05")
 }
+
+// formatUptime renders a stream duration like "2 ч. 15 мин."
+func formatUptime(d time.Duration) string {
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+
+	if hours == 0 {
+		return fmt.Sprintf("%d мин.", minutes)
+	}
+
+	return fmt.Sprintf("%d ч. %d мин.", hours, minutes)
+}
+
+// isMention reports whether the text mentions the bot by its username, persona name or aliases.
+// Only whole words count, the alias "дурка" is not mentioned by "придурка".
+func isMention(text, botUsername string, persona config.Persona) bool {
+	text = strings.ToLower(text)
+
+	names := append([]string{botUsername, persona.Name}, persona.Aliases...)
+	for _, name := range names {
+		if name != "" && containsWord(text, strings.ToLower(name)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsWord reports whether word occurs in the text not surrounded by letters, digits or underscores
+func containsWord(text, word string) bool {
+	for offset := 0; offset < len(text); {
+		index := strings.Index(text[offset:], word)
+		if index < 0 {
+			return false
+		}
+
+		start := offset + index
+		end := start + len(word)
+
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !isWordRune(before) && !isWordRune(after) {
+			return true
+		}
+
+		_, size := utf8.DecodeRuneInString(text[start:])
+		offset = start + size
+	}
+
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isAddressed reports whether the message is addressed to the bot: it mentions the bot
+// or replies to a message of the bot in a thread
+func isAddressed(msg queue.Message, botUsername string, persona config.Persona) bool {
+	if msg.ReplyParent != nil && strings.EqualFold(msg.ReplyParent.Username, botUsername) {
+		return true
+	}
+
+	return isMention(msg.Text, botUsername, persona)
+}
+
+// mention prefixes the reply to a chat message with @username unless the author is already mentioned.
+// The text is shortened if the result would get too long, the mention is always kept.
+func mention(message chatMessage, text string) string {
+	if message.Source != queue.SourceChat {
+		return text
+	}
+
+	name := "@" + message.Username
+	if strings.Contains(strings.ToLower(text), strings.ToLower(name)) {
+		return text
+	}
+
+	prefix := name + " "
+
+	return prefix + truncate(text, maxMessageLength-len(prefix))
+}
+
+// truncate shortens the text to at most limit bytes, cutting on a word boundary where possible
+func truncate(text string, limit int) string {
+	const ellipsis = "…"
+
+	if len(text) <= limit {
+		return text
+	}
+
+	cut := max(limit-len(ellipsis), 0)
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	if space := strings.LastIndexByte(text[:cut], ' '); space > 0 {
+		cut = space
+	}
+
+	return strings.TrimSpace(text[:cut]) + ellipsis
+}
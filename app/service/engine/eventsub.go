@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"durkalive/app/client/eventsub"
+	"durkalive/app/service/queue"
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// handleNotification turns an eventsub notification into a queue event of its channel
+func (s *Service) handleNotification(notification eventsub.Notification) {
+	switch notification.Type {
+	case helix.EventSubTypeStreamOnline:
+		var event helix.EventSubStreamOnlineEvent
+		if decodeEvent(notification, &event) {
+			s.addEvent(event.BroadcasterUserLogin, queue.EventStreamOnline, queue.EventDetails{})
+		}
+	case helix.EventSubTypeStreamOffline:
+		var event helix.EventSubStreamOfflineEvent
+		if decodeEvent(notification, &event) {
+			s.addEvent(event.BroadcasterUserLogin, queue.EventStreamOffline, queue.EventDetails{})
+		}
+	case helix.EventSubTypeChannelUpdate:
+		var event helix.EventSubChannelUpdateEvent
+		if decodeEvent(notification, &event) {
+			s.streamInfoSvc.UpdateChannel(strings.ToLower(event.BroadcasterUserLogin), event.Title, event.CategoryName)
+			s.addEvent(event.BroadcasterUserLogin, queue.EventChannelUpdate, queue.EventDetails{
+				Title:    event.Title,
+				Category: event.CategoryName,
+			})
+		}
+	case helix.EventSubTypeChannelRaid:
+		var event helix.EventSubChannelRaidEvent
+		if decodeEvent(notification, &event) {
+			s.addEvent(event.ToBroadcasterUserLogin, queue.EventRaid, queue.EventDetails{
+				Username: event.FromBroadcasterUserLogin,
+				Viewers:  event.Viewers,
+			})
+		}
+	case helix.EventSubTypeChannelFollow:
+		var event helix.EventSubChannelFollowEvent
+		if decodeEvent(notification, &event) {
+			s.addEvent(event.BroadcasterUserLogin, queue.EventFollow, queue.EventDetails{
+				Username: event.UserLogin,
+			})
+		}
+	case helix.EventSubTypeChannelSubscription:
+		var event helix.EventSubChannelSubscribeEvent
+		// renewals come as subscription messages
+		if decodeEvent(notification, &event) {
+			s.addEvent(event.BroadcasterUserLogin, queue.EventSubscribe, queue.EventDetails{
+				Username: event.UserLogin,
+				Tier:     event.Tier,
+			})
+		}
+	case helix.EventSubTypeChannelSubscriptionMessage:
+		var event helix.EventSubChannelSubscriptionMessageEvent
+		if decodeEvent(notification, &event) {
+			s.addEvent(event.BroadcasterUserLogin, queue.EventSubscribe, queue.EventDetails{
+				Username: event.UserLogin,
+				Text:     event.Message.Text,
+				Tier:     event.Tier,
+				Months:   event.CumulativeMonths,
+			})
+		}
+	case helix.EventSubTypeChannelCheer:
+		var event helix.EventSubChannelCheerEvent
+		if decodeEvent(notification, &event) {
+			s.addEvent(event.BroadcasterUserLogin, queue.EventCheer, queue.EventDetails{
+				// empty for anonymous cheers
+				Username: event.UserLogin,
+				Text:     event.Message,
+				Bits:     event.Bits,
+			})
+		}
+	case helix.EventSubTypeChannelPointsCustomRewardRedemptionAdd:
+		var event helix.EventSubChannelPointsCustomRewardRedemptionEvent
+		if decodeEvent(notification, &event) {
+			s.addEvent(event.BroadcasterUserLogin, queue.EventRedemption, queue.EventDetails{
+				Username: event.UserLogin,
+				Text:     event.UserInput,
+				Reward:   event.Reward.Title,
+			})
+		}
+	default:
+		slog.Debug("Unhandled eventsub notification", "type", notification.Type)
+	}
+}
+
+// addEvent queues the event, events are never dropped unlike chat messages
+func (s *Service) addEvent(channel string, event queue.EventType, details queue.EventDetails) {
+	s.queueSvc.AddEvent(strings.ToLower(channel), event, details)
+}
+
+func decodeEvent(notification eventsub.Notification, event any) bool {
+	if err := json.Unmarshal(notification.Event, event); err != nil {
+		slog.Warn("Failed to decode eventsub notification", "type", notification.Type, "error", err)
+		return false
+	}
+
+	return true
+}
@@ -2,22 +2,44 @@ package engine
 
 import (
 	"context"
+	"durkalive/app/client/eventsub"
+	"durkalive/app/client/twitch"
 	"durkalive/app/client/twitch_live"
 	"durkalive/app/config"
 	"durkalive/app/service/conversation"
 	"durkalive/app/service/queue"
+	"durkalive/app/service/streaminfo"
 	"durkalive/app/service/transcribe"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/elliotchance/pie/v2"
 	"github.com/samber/do"
 )
 
+const (
+	// offlinePollGrowth is the factor the polling interval grows by while the channel stays offline
+	offlinePollGrowth = 1.5
+	// restartDelay is the pause before the pipeline is restarted on a live stream, it doubles with every failure
+	restartDelay    = 5 * time.Second
+	maxRestartDelay = 5 * time.Minute
+	// stablePipeline is how long the pipeline must run for its restarts to count from scratch
+	stablePipeline = time.Minute
+	// alertFailures is the number of consecutive pipeline failures reported as an error
+	alertFailures = 5
+)
+
+var errStreamOffline = errors.New("stream went offline")
+
 type Service struct {
 	cfg             *config.Config
+	twitchClient    *twitch.Client
 	liveClient      *twitch_live.Client
+	eventsubClient  *eventsub.Client
+	streamInfoSvc   *streaminfo.Service
 	transcribeSvc   *transcribe.Service
 	conversationSvc *conversation.Service
 	queueSvc        *queue.Service
@@ -26,65 +48,313 @@ type Service struct {
 func New(di *do.Injector) (*Service, error) {
 	return &Service{
 		cfg:             do.MustInvoke[*config.Config](di),
+		twitchClient:    do.MustInvoke[*twitch.Client](di),
 		liveClient:      do.MustInvoke[*twitch_live.Client](di),
+		eventsubClient:  do.MustInvoke[*eventsub.Client](di),
+		streamInfoSvc:   do.MustInvoke[*streaminfo.Service](di),
 		transcribeSvc:   do.MustInvoke[*transcribe.Service](di),
 		conversationSvc: do.MustInvoke[*conversation.Service](di),
 		queueSvc:        do.MustInvoke[*queue.Service](di),
 	}, nil
 }
 
+// Run starts the shared chat and eventsub connections and an independent pipeline for every channel
 func (s *Service) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.transcribeSvc.RunChat(ctx)
+	}()
+
+	if s.cfg.Twitch.EventSub.Enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.eventsubClient.Run(ctx, s.handleNotification)
+		}()
+	}
+
+	for _, channel := range s.cfg.Twitch.Channels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runChannel(ctx, channel.Name)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// runChannel follows the channel going live and offline, the pipeline runs only while the stream is live
+func (s *Service) runChannel(ctx context.Context, channel string) {
+	var (
+		// startedAt identifies the stream being processed, zero while the channel is offline
+		startedAt time.Time
+		// failures is the number of pipeline runs in a row that stopped early on a live stream
+		failures int
+	)
+
+	// endStream is called when the channel goes offline or a new stream starts, the failures start over
+	endStream := func() {
+		failures = 0
+
+		if startedAt.IsZero() {
+			return
+		}
+
+		s.conversationSvc.EndStream(channel)
+		startedAt = time.Time{}
+	}
+
 	for {
+		liveSince, err := s.waitOnline(ctx, channel, endStream)
+		if err != nil {
+			return
+		}
+
+		if !liveSince.Equal(startedAt) {
+			endStream()
+
+			if err = s.conversationSvc.BeginStream(ctx, channel, liveSince); err != nil {
+				slog.Warn("Could not begin stream", "channel", channel, "error", err)
+			} else {
+				startedAt = liveSince
+			}
+		}
+
+		pipelineStart := time.Now()
+
+		err = s.runStream(ctx, channel)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(pipelineStart) >= stablePipeline {
+			failures = 0
+		}
+		failures++
+
+		delay := min(restartDelay<<min(failures-1, 16), maxRestartDelay)
+
+		// the playlist may lag behind the stream status for a while, only repeated failures are errors
+		if failures%alertFailures == 0 {
+			slog.Error("Stream pipeline keeps failing",
+				"channel", channel,
+				"failures", failures,
+				"reason", err,
+				"retry_in", delay)
+		} else {
+			slog.Info("Stream pipeline stopped", "channel", channel, "reason", err, "retry_in", delay)
+		}
+
 		select {
 		case <-ctx.Done():
 			return
-		default:
+		case <-time.After(delay):
 		}
+	}
+}
+
+// waitOnline polls the stream status until the channel is live and returns the start time of the stream.
+// onOffline is called every time the channel is seen offline. Meanwhile the events still reach the conversation,
+// the stream online event from eventsub cuts the wait short. Leftover chat and speech is dropped.
+func (s *Service) waitOnline(ctx context.Context, channel string, onOffline func()) (time.Time, error) {
+	interval := s.cfg.Twitch.OfflinePoll
+	messages := s.queueSvc.Channel(channel)
+	events := s.queueSvc.Events(channel)
+	logged := false
 
-		if err := s.runIteration(ctx); err != nil {
-			slog.Error("Error running iteration", "error", err)
-			time.Sleep(time.Minute)
+	for {
+		startedAt, err := s.twitchClient.GetStreamStartedAt(channel)
+		if err == nil {
+			if logged {
+				slog.Info("Channel is live", "channel", channel, "started_at", startedAt)
+			}
+			return startedAt, nil
+		}
+
+		if errors.Is(err, twitch.ErrStreamOffline) {
+			onOffline()
+
+			if !logged {
+				slog.Info("Channel is offline", "channel", channel)
+				logged = true
+			}
+		} else {
+			slog.Warn("Could not get stream status", "channel", channel, "error", err)
+		}
+
+		timer := time.NewTimer(interval)
+		interval = min(time.Duration(float64(interval)*offlinePollGrowth), s.cfg.Twitch.MaxOfflinePoll)
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return time.Time{}, ctx.Err()
+			case <-timer.C:
+				break wait
+			case msg, ok := <-messages:
+				if !ok {
+					timer.Stop()
+					return time.Time{}, context.Canceled
+				}
+
+				slog.Debug("Dropped message of an offline channel", "channel", channel, "username", msg.Username)
+			case <-events:
+				msg, ok := s.queueSvc.NextEvent(channel)
+				if !ok {
+					continue
+				}
+
+				switch msg.Event {
+				case queue.EventStreamOnline:
+					timer.Stop()
+					// helix may lag behind eventsub, keep polling often for a while
+					interval = s.cfg.Twitch.OfflinePoll
+					break wait
+				case queue.EventStreamOffline:
+					slog.Debug("Channel went offline", "channel", channel)
+				default:
+					// e.g. the end of an ad break that outlived the pipeline
+					if err := s.conversationSvc.ProcessEvent(channel, msg.Event, msg.Details); err != nil {
+						slog.Warn("ProcessEvent error", "channel", channel, "error", err)
+					}
+				}
+			}
 		}
 	}
 }
 
-func (s *Service) runIteration(ctx context.Context) error {
-	qualities, err := s.liveClient.GetM3U8(ctx, s.cfg.Twitch.Channel)
+// runStream runs the pipeline on the live stream until it stops
+func (s *Service) runStream(ctx context.Context, channel string) error {
+	playlist, err := s.liveClient.GetM3U8(ctx, channel)
 	if err != nil {
 		return fmt.Errorf("could not get qualities: %w", err)
 	}
 
-	qualityIndex := pie.FindFirstUsing(qualities, func(q twitch_live.StreamQuality) bool {
-		return q.Quality == "audio_only"
-	})
-	if qualityIndex < 0 {
-		qualityIndex = 0
-	}
-
-	streamQuality := qualities[qualityIndex]
+	streamQuality := SelectQuality(playlist.Qualities, s.cfg.Twitch.Qualities)
 	streamURL := streamQuality.URL
 
-	transcribeCtx, cancel := s.transcribeSvc.Start(ctx, streamURL)
+	slog.Info("Selected stream quality",
+		"channel", channel,
+		"quality", streamQuality.Quality,
+		"bandwidth", streamQuality.Bandwidth,
+		"codecs", streamQuality.Codecs)
+
+	return s.RunPipeline(ctx, channel, transcribe.Source{Playlist: streamURL})
+}
+
+// RunPipeline transcribes the source and processes the queue of the channel until the transcription stops.
+// Replays process the messages left in the queue before returning.
+func (s *Service) RunPipeline(ctx context.Context, channel string, source transcribe.Source) error {
+	transcribeCtx, cancel := s.transcribeSvc.Start(ctx, channel, source)
 	defer cancel(nil)
 
+	messages := s.queueSvc.Channel(channel)
+	events := s.queueSvc.Events(channel)
+
 	for {
 		select {
 		case <-transcribeCtx.Done():
+			if source.Replay && ctx.Err() == nil {
+				s.drainQueue(ctx, channel)
+			}
+
 			return context.Cause(transcribeCtx)
-		case msg, ok := <-s.queueSvc.Channel():
+		case <-events:
+			if msg, ok := s.queueSvc.NextEvent(channel); ok {
+				if err := s.processMessage(ctx, channel, msg); err != nil {
+					return err
+				}
+			}
+		case msg, ok := <-messages:
 			if !ok {
 				return context.Canceled
 			}
 
-			start := time.Now()
-			if err = s.conversationSvc.ProcessMessage(ctx, msg.Username, msg.Text); err != nil {
-				slog.Warn("ProcessMessage error", "error", err)
+			if err := s.processMessage(ctx, channel, msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// drainQueue processes the events and the messages already in the queue
+func (s *Service) drainQueue(ctx context.Context, channel string) {
+	for {
+		msg, ok := s.queueSvc.NextEvent(channel)
+		if !ok {
+			break
+		}
+
+		if err := s.processMessage(ctx, channel, msg); err != nil {
+			return
+		}
+	}
+
+	messages := s.queueSvc.Channel(channel)
+
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			if err := s.processMessage(ctx, channel, msg); err != nil {
+				return
 			}
+		default:
+			return
+		}
+	}
+}
 
-			slog.Info("Processed message",
-				"username", msg.Username,
-				"text", msg.Text,
-				"duration", time.Since(start))
+// processMessage passes the message to the conversation, errStreamOffline is returned when the stream ends
+func (s *Service) processMessage(ctx context.Context, channel string, msg queue.Message) error {
+	if msg.Event != "" {
+		if err := s.conversationSvc.ProcessEvent(channel, msg.Event, msg.Details); err != nil {
+			slog.Warn("ProcessEvent error", "channel", channel, "error", err)
 		}
+
+		if msg.Event == queue.EventStreamOffline {
+			return errStreamOffline
+		}
+		return nil
+	}
+
+	start := time.Now()
+	if err := s.conversationSvc.ProcessMessage(ctx, channel, msg); err != nil {
+		slog.Warn("ProcessMessage error", "channel", channel, "error", err)
 	}
+
+	slog.Info("Processed message",
+		"channel", channel,
+		"username", msg.Username,
+		"source", msg.Source,
+		"text", msg.Text,
+		"duration", time.Since(start))
+
+	return nil
+}
+
+// SelectQuality picks the first available preferred quality, falling back to the one with the lowest bandwidth.
+// qualities must not be empty.
+func SelectQuality(qualities []twitch_live.StreamQuality, preferred []string) twitch_live.StreamQuality {
+	for _, name := range preferred {
+		index := pie.FindFirstUsing(qualities, func(q twitch_live.StreamQuality) bool {
+			return q.Quality == name || q.GroupID == name
+		})
+		if index >= 0 {
+			return qualities[index]
+		}
+	}
+
+	return pie.SortUsing(qualities, func(a, b twitch_live.StreamQuality) bool {
+		return a.Bandwidth < b.Bandwidth
+	})[0]
 }
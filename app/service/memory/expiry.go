@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"context"
+	"durkalive/app/service/storage"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const pruneInterval = time.Minute
+
+func isExpired(fact storage.Fact, now time.Time) bool {
+	return fact.ExpiresAt != nil && !fact.ExpiresAt.After(now)
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%d мин.", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%d ч.", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d дн.", int(d.Hours()/24))
+	}
+}
+
+// FormatLifetime renders the age of the fact and the time left before it expires
+func FormatLifetime(fact storage.Fact, now time.Time) string {
+	result := "добавлен " + formatDuration(now.Sub(fact.CreatedAt)) + " назад"
+
+	if fact.ExpiresAt != nil {
+		result += ", истекает через " + formatDuration(fact.ExpiresAt.Sub(now))
+	}
+
+	return result
+}
+
+func (s *Service) RunPruneLoop(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.pruneExpired(ctx); err != nil {
+				slog.Error("Failed to prune expired facts", "error", err)
+			}
+		}
+	}
+}
+
+func (s *Service) pruneExpired(ctx context.Context) error {
+	ids, err := s.storageSvc.DeleteExpiredFacts(ctx, s.clock.Now())
+	if err != nil {
+		return err
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	expired := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		expired[id] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remaining := make([]storage.Fact, 0, len(s.facts))
+	removedFacts := make([]string, 0, len(ids))
+
+	for _, fact := range s.facts {
+		if expired[fact.ID] {
+			removedFacts = append(removedFacts, fact.Text)
+			s.index.remove(fact.ID)
+			continue
+		}
+
+		remaining = append(remaining, fact)
+	}
+
+	s.facts = remaining
+
+	slog.Info("Pruned expired facts",
+		"count", len(removedFacts),
+		"remaining", len(s.facts),
+		"removed", removedFacts)
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"math"
+	"sort"
+)
+
+// vectorIndex is a brute-force cosine similarity index over fact embeddings
+type vectorIndex struct {
+	vectors map[int64][]float32
+}
+
+func newVectorIndex() *vectorIndex {
+	return &vectorIndex{
+		vectors: make(map[int64][]float32),
+	}
+}
+
+func normalize(vec []float32) []float32 {
+	var sum float64
+	for _, v := range vec {
+		sum += float64(v) * float64(v)
+	}
+
+	norm := math.Sqrt(sum)
+	if norm == 0 {
+		return nil
+	}
+
+	result := make([]float32, len(vec))
+	for i, v := range vec {
+		result[i] = float32(float64(v) / norm)
+	}
+
+	return result
+}
+
+// set adds the embedding of the fact, a zero vector is kept empty so the fact counts as embedded but never matches
+func (i *vectorIndex) set(id int64, vec []float32) {
+	normalized := normalize(vec)
+	if normalized == nil {
+		normalized = []float32{}
+	}
+
+	i.vectors[id] = normalized
+}
+
+func (i *vectorIndex) remove(id int64) {
+	delete(i.vectors, id)
+}
+
+func (i *vectorIndex) has(id int64) bool {
+	_, ok := i.vectors[id]
+	return ok
+}
+
+// search returns up to k ids allowed by the filter, most similar to the query first
+func (i *vectorIndex) search(query []float32, k int, allow func(id int64) bool) []int64 {
+	query = normalize(query)
+	if query == nil {
+		return nil
+	}
+
+	type scored struct {
+		id    int64
+		score float64
+	}
+
+	candidates := make([]scored, 0, len(i.vectors))
+	for id, vec := range i.vectors {
+		if !allow(id) || len(vec) != len(query) {
+			continue
+		}
+
+		var dot float64
+		for j := range vec {
+			dot += float64(vec[j]) * float64(query[j])
+		}
+
+		candidates = append(candidates, scored{id: id, score: dot})
+	}
+
+	sort.Slice(candidates, func(a, b int) bool {
+		return candidates[a].score > candidates[b].score
+	})
+
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+
+	result := make([]int64, 0, len(candidates))
+	for _, c := range candidates {
+		result = append(result, c.id)
+	}
+
+	return result
+}
@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// retrieve picks the ids of top-K facts of the channel among the given subjects that are most similar to the query.
+// If the query can't be embedded, the latest facts are picked instead.
+func (s *Service) retrieve(ctx context.Context, channel string, subjects map[string]bool, query string) map[int64]bool {
+	if err := s.backfillEmbeddings(ctx); err != nil {
+		slog.Warn("Failed to backfill fact embeddings", "error", err)
+	}
+
+	queryVec, err := s.embedQuery(ctx, query)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.clock.Now()
+
+	allowed := make(map[int64]bool, len(s.facts))
+	for _, fact := range s.facts {
+		if fact.Channel == channel && subjects[fact.Subject] && !isExpired(fact, now) {
+			allowed[fact.ID] = true
+		}
+	}
+
+	result := make(map[int64]bool, s.cfg.Memory.TopK)
+
+	if err != nil {
+		slog.Warn("Failed to embed facts query, using latest facts", "error", err)
+
+		for i := len(s.facts) - 1; i >= 0 && len(result) < s.cfg.Memory.TopK; i-- {
+			if allowed[s.facts[i].ID] {
+				result[s.facts[i].ID] = true
+			}
+		}
+
+		return result
+	}
+
+	ids := s.index.search(queryVec, s.cfg.Memory.TopK, func(id int64) bool {
+		return allowed[id]
+	})
+	for _, id := range ids {
+		result[id] = true
+	}
+
+	return result
+}
+
+func (s *Service) embedQuery(ctx context.Context, query string) ([]float32, error) {
+	s.queryMu.Lock()
+	defer s.queryMu.Unlock()
+
+	// decision and reply agents usually ask for the same query in a row
+	if query == s.lastQuery && s.lastQueryVec != nil {
+		return s.lastQueryVec, nil
+	}
+
+	vectors, err := s.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	s.lastQuery = query
+	s.lastQueryVec = vectors[0]
+
+	return vectors[0], nil
+}
+
+// backfillEmbeddings embeds facts that are missing from the index and persists the vectors.
+// The facts are embedded without holding mu, the ones removed in the meantime are not put back into the index.
+func (s *Service) backfillEmbeddings(ctx context.Context) error {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+
+	s.mu.RLock()
+	ids := make([]int64, 0)
+	texts := make([]string, 0)
+	for _, fact := range s.facts {
+		if len(ids) >= maxBackfillBatch {
+			break
+		}
+		if !s.index.has(fact.ID) {
+			ids = append(ids, fact.ID)
+			texts = append(texts, fact.Text)
+		}
+	}
+	s.mu.RUnlock()
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	vectors, err := s.embedder.Embed(ctx, texts)
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		if err = s.storageSvc.SetFactEmbedding(ctx, id, vectors[i]); err != nil {
+			return fmt.Errorf("failed to store embedding of fact %d: %w", id, err)
+		}
+	}
+
+	s.mu.Lock()
+	existing := make(map[int64]bool, len(s.facts))
+	for _, fact := range s.facts {
+		existing[fact.ID] = true
+	}
+	for i, id := range ids {
+		if existing[id] {
+			s.index.set(id, vectors[i])
+		}
+	}
+	s.mu.Unlock()
+
+	slog.Debug("Backfilled fact embeddings", "count", len(ids))
+
+	return nil
+}
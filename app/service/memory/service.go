@@ -1,8 +1,11 @@
 package memory
 
 import (
-	"bufio"
+	"context"
+	"durkalive/app/client/embeddings"
 	"durkalive/app/config"
+	"durkalive/app/service/storage"
+	"durkalive/app/util/clock"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -10,72 +13,159 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/samber/do"
 )
 
-var dbFilePath = filepath.Join("data", "facts.json")
+var legacyFilePath = filepath.Join("data", "facts.json")
+
+const (
+	SubjectStreamer = "streamer"
+	SubjectChannel  = "channel"
+
+	maxBackfillBatch = 64
+)
+
+type NewFact struct {
+	// Subject is SubjectStreamer, SubjectChannel or a viewer login
+	Subject string
+	Text    string
+	// TTL of the fact, zero means the fact never expires
+	TTL time.Duration
+}
+
+// Provenance describes where the facts came from
+type Provenance struct {
+	// MessageID of the stored message that triggered the facts, zero if unknown
+	MessageID int64
+	Username  string
+	Model     string
+}
+
+type factKey struct {
+	subject string
+	text    string
+}
 
 type Service struct {
-	cfg   *config.Config
+	cfg        *config.Config
+	storageSvc *storage.Service
+	clock      clock.Clock
+	// embedder is nil unless semantic retrieval is enabled
+	embedder *embeddings.Client
+
 	mu    sync.RWMutex
-	facts []string
+	facts []storage.Fact
+	index *vectorIndex
+
+	queryMu      sync.Mutex
+	lastQuery    string
+	lastQueryVec []float32
+
+	// backfillMu keeps concurrent retrievals from embedding the same facts twice
+	backfillMu sync.Mutex
 }
 
 func New(di *do.Injector) (*Service, error) {
-	_ = os.MkdirAll("data", 0755)
+	ctx := do.MustInvoke[context.Context](di)
+	cfg := do.MustInvoke[*config.Config](di)
+
+	s := &Service{
+		cfg:        cfg,
+		storageSvc: do.MustInvoke[*storage.Service](di),
+		clock:      do.MustInvoke[clock.Clock](di),
+		index:      newVectorIndex(),
+	}
 
-	facts, err := loadFacts()
-	if err != nil {
-		slog.Warn("Error loading facts", "err", err)
-		facts = []string{}
+	if cfg.Memory.Retrieval == "semantic" {
+		s.embedder = do.MustInvoke[*embeddings.Client](di)
 	}
 
-	return &Service{
-		cfg:   do.MustInvoke[*config.Config](di),
-		facts: facts,
-	}, nil
-}
+	// facts learned before multi-channel support belong to the first channel
+	defaultChannel := cfg.Twitch.Channels[0].Name
+
+	claimed, err := s.storageSvc.ClaimOrphanFacts(ctx, defaultChannel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim orphan facts: %w", err)
+	}
+	if claimed > 0 {
+		slog.Info("Moved facts without a channel", "channel", defaultChannel, "count", claimed)
+	}
 
-func loadFacts() ([]string, error) {
-	file, err := os.OpenFile(dbFilePath, os.O_RDONLY|os.O_CREATE, 0644)
+	facts, err := s.storageSvc.ListFacts(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open facts file: %w", err)
+		return nil, fmt.Errorf("failed to load facts: %w", err)
 	}
-	defer file.Close()
+	s.facts = facts
 
-	var facts []string
-	decoder := json.NewDecoder(file)
-	if err = decoder.Decode(&facts); err != nil {
-		return nil, fmt.Errorf("failed to decode facts: %w", err)
+	for _, fact := range facts {
+		if fact.Embedding != nil {
+			s.index.set(fact.ID, fact.Embedding)
+		}
 	}
 
-	return facts, nil
+	if len(s.facts) == 0 {
+		if err = s.importLegacyFacts(ctx, defaultChannel); err != nil {
+			slog.Warn("Error importing legacy facts", "err", err)
+		}
+	}
+
+	return s, nil
 }
 
-func (s *Service) saveFacts() error {
-	file, err := os.OpenFile(dbFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+// importLegacyFacts moves facts from the old JSON file into the database
+func (s *Service) importLegacyFacts(ctx context.Context, channel string) error {
+	data, err := os.ReadFile(legacyFilePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create/open facts file: %w", err)
+		return fmt.Errorf("failed to read legacy facts file: %w", err)
 	}
-	defer file.Close()
 
-	writer := bufio.NewWriter(file)
-	encoder := json.NewEncoder(writer)
-	encoder.SetIndent("", "  ")
+	var texts []string
+	if err = json.Unmarshal(data, &texts); err != nil {
+		return fmt.Errorf("failed to decode legacy facts: %w", err)
+	}
 
-	if err = encoder.Encode(s.facts); err != nil {
-		return fmt.Errorf("failed to encode facts: %w", err)
+	facts := make([]NewFact, 0, len(texts))
+	for _, text := range texts {
+		facts = append(facts, NewFact{
+			Subject: SubjectChannel,
+			Text:    text,
+		})
 	}
 
-	if err = writer.Flush(); err != nil {
-		return fmt.Errorf("failed to flush writer: %w", err)
+	if err = s.AddFacts(ctx, channel, facts, Provenance{}); err != nil {
+		return fmt.Errorf("failed to import legacy facts: %w", err)
 	}
 
+	if err = os.Rename(legacyFilePath, legacyFilePath+".imported"); err != nil {
+		return fmt.Errorf("failed to rename legacy facts file: %w", err)
+	}
+
+	slog.Info("Imported legacy facts", "count", len(facts))
+
 	return nil
 }
 
-func (s *Service) AddFacts(facts []string) error {
+// normalizeSubject maps the subject written by the model to the stored form
+func normalizeSubject(channel, subject string) string {
+	subject = strings.ToLower(strings.TrimSpace(subject))
+	subject = strings.TrimPrefix(subject, "@")
+
+	switch subject {
+	case "", SubjectChannel, "chat":
+		return SubjectChannel
+	case SubjectStreamer, channel:
+		return SubjectStreamer
+	default:
+		return "@" + subject
+	}
+}
+
+func (s *Service) AddFacts(ctx context.Context, channel string, facts []NewFact, provenance Provenance) error {
 	if len(facts) == 0 {
 		return nil
 	}
@@ -83,103 +173,186 @@ func (s *Service) AddFacts(facts []string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	newFacts := make([]string, 0, len(facts))
-	existing := make(map[string]bool)
+	newFacts := make([]storage.Fact, 0, len(facts))
+	existing := make(map[factKey]bool)
 
 	for _, fact := range s.facts {
-		existing[fact] = true
+		if fact.Channel == channel {
+			existing[factKey{subject: fact.Subject, text: fact.Text}] = true
+		}
+	}
+
+	var sourceMessageID *int64
+	if provenance.MessageID != 0 {
+		sourceMessageID = &provenance.MessageID
 	}
 
+	now := s.clock.Now()
+
 	for _, fact := range facts {
-		fact = strings.TrimSpace(fact)
-		if fact == "" {
+		key := factKey{
+			subject: normalizeSubject(channel, fact.Subject),
+			text:    strings.TrimSpace(fact.Text),
+		}
+		if key.text == "" || existing[key] {
 			continue
 		}
-		if !existing[fact] {
-			newFacts = append(newFacts, fact)
-			existing[fact] = true
+
+		var expiresAt *time.Time
+		if fact.TTL > 0 {
+			expiresAt = new(time.Time)
+			*expiresAt = now.Add(fact.TTL)
 		}
+
+		newFacts = append(newFacts, storage.Fact{
+			Channel:         channel,
+			Subject:         key.subject,
+			Text:            key.text,
+			CreatedAt:       now,
+			SourceMessageID: sourceMessageID,
+			SourceUsername:  provenance.Username,
+			Model:           provenance.Model,
+			ExpiresAt:       expiresAt,
+		})
+		existing[key] = true
 	}
 
 	if len(newFacts) == 0 {
 		return nil
 	}
 
-	s.facts = append(s.facts, newFacts...)
-
-	if err := s.saveFacts(); err != nil {
-		return fmt.Errorf("failed to save facts after addition: %w", err)
+	inserted, err := s.storageSvc.InsertFacts(ctx, newFacts)
+	if err != nil {
+		return fmt.Errorf("failed to save facts: %w", err)
 	}
 
-	slog.Info("Added facts", "facts", facts, "total", len(s.facts))
+	s.facts = append(s.facts, inserted...)
+
+	slog.Info("Added facts", "channel", channel, "facts", newFacts, "total", len(s.facts))
 
 	return nil
 }
 
-func (s *Service) RemoveFacts(indices []int) error {
-	if len(indices) == 0 {
-		return nil
+// RemoveFacts deletes facts of the channel by their stable IDs, returning the IDs that are not known
+func (s *Service) RemoveFacts(ctx context.Context, channel string, ids []int64) ([]int64, error) {
+	if len(ids) == 0 {
+		return nil, nil
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if len(s.facts) == 0 {
-		return fmt.Errorf("no facts to remove")
+	known := make(map[int64]bool, len(s.facts))
+	for _, fact := range s.facts {
+		if fact.Channel == channel {
+			known[fact.ID] = true
+		}
 	}
 
-	validIndices := make([]int, 0, len(indices))
-	indexMap := make(map[int]bool)
+	removeIDs := make([]int64, 0, len(ids))
+	removeMap := make(map[int64]bool)
+	var unknownIDs []int64
 
-	for _, idx := range indices {
-		if idx < 0 || idx >= len(s.facts) {
-			return fmt.Errorf("invalid index %d: out of range [0, %d]", idx, len(s.facts)-1)
+	for _, id := range ids {
+		if !known[id] {
+			unknownIDs = append(unknownIDs, id)
+			continue
 		}
-		if !indexMap[idx] {
-			validIndices = append(validIndices, idx)
-			indexMap[idx] = true
+		if !removeMap[id] {
+			removeIDs = append(removeIDs, id)
+			removeMap[id] = true
 		}
 	}
 
-	for i := 0; i < len(validIndices)-1; i++ {
-		for j := i + 1; j < len(validIndices); j++ {
-			if validIndices[i] < validIndices[j] {
-				validIndices[i], validIndices[j] = validIndices[j], validIndices[i]
-			}
-		}
+	if len(removeIDs) == 0 {
+		return unknownIDs, nil
+	}
+
+	if err := s.storageSvc.DeleteFacts(ctx, removeIDs); err != nil {
+		return nil, fmt.Errorf("failed to delete facts: %w", err)
 	}
 
-	removedFacts := make([]string, 0, len(validIndices))
+	remaining := make([]storage.Fact, 0, len(s.facts)-len(removeIDs))
+	removedFacts := make([]string, 0, len(removeIDs))
+
+	for _, fact := range s.facts {
+		if removeMap[fact.ID] {
+			removedFacts = append(removedFacts, fact.Text)
+			continue
+		}
 
-	for _, idx := range validIndices {
-		removedFacts = append(removedFacts, s.facts[idx])
-		s.facts = append(s.facts[:idx], s.facts[idx+1:]...)
+		remaining = append(remaining, fact)
 	}
 
-	if err := s.saveFacts(); err != nil {
-		return fmt.Errorf("failed to save facts after removal: %w", err)
+	s.facts = remaining
+
+	for _, id := range removeIDs {
+		s.index.remove(id)
 	}
 
 	slog.Info("Removed facts",
+		"channel", channel,
 		"count", len(removedFacts),
 		"remaining", len(s.facts),
 		"removed", removedFacts)
 
-	return nil
+	return unknownIDs, nil
 }
 
-func (s *Service) Format() string {
+// UnknownFacts returns the IDs that are not facts of the channel, RemoveFacts would skip them
+func (s *Service) UnknownFacts(channel string, ids []int64) []int64 {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if len(s.facts) == 0 {
-		return "No facts"
+	known := make(map[int64]bool, len(s.facts))
+	for _, fact := range s.facts {
+		if fact.Channel == channel {
+			known[fact.ID] = true
+		}
 	}
 
-	var builder strings.Builder
-	for i, fact := range s.facts {
-		builder.WriteString(fmt.Sprintf("%d - %s\n", i+1, fact))
+	var unknownIDs []int64
+	for _, id := range ids {
+		if !known[id] {
+			unknownIDs = append(unknownIDs, id)
+		}
+	}
+
+	return unknownIDs
+}
+
+// Relevant returns facts of the channel about the streamer, the channel and the given viewers ordered by ID.
+// In semantic mode only the facts most relevant to the query are returned.
+func (s *Service) Relevant(ctx context.Context, channel string, usernames []string, query string) []storage.Fact {
+	subjects := map[string]bool{
+		SubjectStreamer: true,
+		SubjectChannel:  true,
+	}
+	for _, username := range usernames {
+		subjects[normalizeSubject(channel, username)] = true
+	}
+
+	var selected map[int64]bool
+	if s.embedder != nil {
+		selected = s.retrieve(ctx, channel, subjects, query)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.clock.Now()
+
+	result := make([]storage.Fact, 0, len(s.facts))
+	for _, fact := range s.facts {
+		if fact.Channel != channel || !subjects[fact.Subject] || isExpired(fact, now) {
+			continue
+		}
+		if selected != nil && !selected[fact.ID] {
+			continue
+		}
+
+		result = append(result, fact)
 	}
 
-	return builder.String()
+	return result
 }
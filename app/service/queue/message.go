@@ -0,0 +1,132 @@
+package queue
+
+import (
+	"strconv"
+	"strings"
+
+	irc "github.com/gempir/go-twitch-irc/v4"
+)
+
+type Source string
+
+const (
+	SourceChat Source = "chat"
+	// SourceSpeech is the streamer's voice recognized by speech to text
+	SourceSpeech Source = "speech"
+)
+
+type Message struct {
+	Source Source
+	// ID is the twitch message id, empty for speech
+	ID       string
+	Username string
+	// DisplayName is the username with the capitalization chosen by the user
+	DisplayName string
+	Text        string
+	Badges      Badges
+	// SubMonths is the number of months the user has been subscribed
+	SubMonths    int
+	Bits         int
+	Color        string
+	FirstMessage bool
+	// ReplyParent is set when the message replies to another one in a thread
+	ReplyParent *ReplyParent
+	Emotes      []Emote
+
+	// Event is set for stream events, the message fields are empty then
+	Event   EventType
+	Details EventDetails
+}
+
+type Badges struct {
+	Broadcaster bool
+	Moderator   bool
+	VIP         bool
+	Subscriber  bool
+}
+
+type ReplyParent struct {
+	MessageID string
+	Username  string
+	Text      string
+}
+
+// Emote is an occurrence of an emote, Start and End are rune offsets in the text, End is inclusive
+type Emote struct {
+	ID    string
+	Name  string
+	Start int
+	End   int
+}
+
+// NewChatMessage converts a chat message received over IRC
+func NewChatMessage(message irc.PrivateMessage) Message {
+	msg := Message{
+		Source:      SourceChat,
+		ID:          message.ID,
+		Username:    strings.ToLower(message.User.Name),
+		DisplayName: message.User.DisplayName,
+		Text:        strings.TrimSpace(message.Message),
+		Badges: Badges{
+			Broadcaster: message.User.Badges["broadcaster"] > 0,
+			Moderator:   message.User.Badges["moderator"] > 0,
+			VIP:         message.User.Badges["vip"] > 0,
+			Subscriber:  message.User.Badges["subscriber"] > 0 || message.User.Badges["founder"] > 0,
+		},
+		SubMonths:    subMonths(message.Tags["badge-info"]),
+		Bits:         message.Bits,
+		Color:        message.User.Color,
+		FirstMessage: message.FirstMessage,
+	}
+
+	if message.Reply != nil {
+		msg.ReplyParent = &ReplyParent{
+			MessageID: message.Reply.ParentMsgID,
+			Username:  strings.ToLower(message.Reply.ParentUserLogin),
+			Text:      message.Reply.ParentMsgBody,
+		}
+	}
+
+	for _, emote := range message.Emotes {
+		for _, position := range emote.Positions {
+			msg.Emotes = append(msg.Emotes, Emote{
+				ID:    emote.ID,
+				Name:  emote.Name,
+				Start: position.Start,
+				End:   position.End,
+			})
+		}
+	}
+
+	return msg
+}
+
+// NewSpeechMessage wraps a phrase of the streamer recognized by speech to text
+func NewSpeechMessage(channel, text string) Message {
+	return Message{
+		Source:      SourceSpeech,
+		Username:    channel,
+		DisplayName: channel,
+		Text:        text,
+		Badges: Badges{
+			Broadcaster: true,
+		},
+	}
+}
+
+// subMonths parses the subscriber months out of the badge-info tag, e.g. subscriber/14
+func subMonths(badgeInfo string) int {
+	for _, badge := range strings.Split(badgeInfo, ",") {
+		name, value, _ := strings.Cut(badge, "/")
+		if name != "subscriber" && name != "founder" {
+			continue
+		}
+
+		months, err := strconv.Atoi(value)
+		if err == nil {
+			return months
+		}
+	}
+
+	return 0
+}
@@ -1,7 +1,11 @@
 package queue
 
 import (
+	"context"
+	"durkalive/app/config"
+	"fmt"
 	"log/slog"
+	"sync"
 
 	"github.com/samber/do"
 )
@@ -10,41 +14,186 @@ const bufferSize = 64
 
 var _ do.Shutdownable = (*Service)(nil)
 
+// Service keeps a separate message queue for every channel.
+// Chat and speech are dropped when the queue is full, events are kept until they are taken.
 type Service struct {
-	queue chan Message
+	queues map[string]chan Message
+	events map[string]*eventQueue
 }
 
-type Message struct {
+// eventQueue is an unbounded queue of events, ready receives a value while events are pending
+type eventQueue struct {
+	mu      sync.Mutex
+	pending []Message
+	ready   chan struct{}
+}
+
+type EventType string
+
+const (
+	EventAdBreakStarted EventType = "ad_break_started"
+	EventAdBreakEnded   EventType = "ad_break_ended"
+	EventStreamOnline   EventType = "stream_online"
+	EventStreamOffline  EventType = "stream_offline"
+	EventChannelUpdate  EventType = "channel_update"
+	EventRaid           EventType = "raid"
+	EventFollow         EventType = "follow"
+	EventSubscribe      EventType = "subscribe"
+	EventCheer          EventType = "cheer"
+	EventRedemption     EventType = "redemption"
+)
+
+// EventDetails describes a stream event, only the fields relevant to its type are set
+type EventDetails struct {
+	// Username is the viewer behind the event: raider, follower, subscriber
 	Username string
+	// Text is the message attached to a subscription, a cheer or a redemption
 	Text     string
+	Title    string
+	Category string
+	Viewers  int
+	// Tier of a subscription: 1000, 2000, 3000
+	Tier   string
+	Months int
+	Bits   int
+	Reward string
 }
 
-func New(_ *do.Injector) (*Service, error) {
+func New(di *do.Injector) (*Service, error) {
+	cfg := do.MustInvoke[*config.Config](di)
+
+	queues := make(map[string]chan Message, len(cfg.Twitch.Channels))
+	events := make(map[string]*eventQueue, len(cfg.Twitch.Channels))
+	for _, channel := range cfg.Twitch.Channels {
+		queues[channel.Name] = make(chan Message, bufferSize)
+		events[channel.Name] = &eventQueue{
+			ready: make(chan struct{}, 1),
+		}
+	}
+
 	return &Service{
-		queue: make(chan Message, bufferSize),
+		queues: queues,
+		events: events,
 	}, nil
 }
 
-func (s *Service) Add(username, text string) {
+func (s *Service) Add(channel string, msg Message) {
+	s.push(channel, msg)
+}
+
+// AddEvent queues the event of the channel, events are never dropped
+func (s *Service) AddEvent(channel string, event EventType, details EventDetails) {
+	events, ok := s.events[channel]
+	if !ok {
+		slog.Warn("event for unknown channel", "channel", channel, "event", event)
+		return
+	}
+
+	events.mu.Lock()
+	defer events.mu.Unlock()
+
+	events.pending = append(events.pending, Message{
+		Event:   event,
+		Details: details,
+	})
+	events.notify()
+}
+
+// Events receives a value while the channel has pending events, they are taken with NextEvent.
+// It returns nil if the channel is unknown.
+func (s *Service) Events(channel string) <-chan struct{} {
+	events, ok := s.events[channel]
+	if !ok {
+		return nil
+	}
+
+	return events.ready
+}
+
+// NextEvent takes the oldest pending event of the channel
+func (s *Service) NextEvent(channel string) (Message, bool) {
+	events, ok := s.events[channel]
+	if !ok {
+		return Message{}, false
+	}
+
+	events.mu.Lock()
+	defer events.mu.Unlock()
+
+	if len(events.pending) == 0 {
+		return Message{}, false
+	}
+
+	msg := events.pending[0]
+	events.pending[0] = Message{}
+	events.pending = events.pending[1:]
+
+	// ready was consumed by the caller, the rest of the events need another wake up
+	if len(events.pending) > 0 {
+		events.notify()
+	}
+
+	return msg, true
+}
+
+func (q *eventQueue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Service) push(channel string, msg Message) {
 	defer func() {
 		if r := recover(); r != nil {
 
 		}
 	}()
 
+	queue, ok := s.queues[channel]
+	if !ok {
+		slog.Warn("message for unknown channel", "channel", channel)
+		return
+	}
+
 	select {
-	case s.queue <- Message{username, text}:
+	case queue <- msg:
 	default:
-		slog.Warn("message queue is full")
+		slog.Warn("message queue is full", "channel", channel)
 	}
 }
 
-func (s *Service) Channel() <-chan Message {
-	return s.queue
+// AddWait blocks until the queue has room for the message, unlike Add it never drops messages
+func (s *Service) AddWait(ctx context.Context, channel string, msg Message) (err error) {
+	// the queues are closed on shutdown
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("queue of channel %q is closed", channel)
+		}
+	}()
+
+	queue, ok := s.queues[channel]
+	if !ok {
+		return fmt.Errorf("unknown channel %q", channel)
+	}
+
+	select {
+	case queue <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Channel returns the queue of the channel, nil if the channel is unknown
+func (s *Service) Channel(channel string) <-chan Message {
+	return s.queues[channel]
 }
 
 func (s *Service) Shutdown() error {
-	close(s.queue)
+	for _, queue := range s.queues {
+		close(queue)
+	}
 
 	return nil
 }
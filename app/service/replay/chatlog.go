@@ -0,0 +1,128 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"durkalive/app/service/queue"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	irc "github.com/gempir/go-twitch-irc/v4"
+)
+
+type chatEntry struct {
+	Time    time.Time
+	Message queue.Message
+}
+
+// jsonChatEntry is a message of a JSON chat log, either Time or Offset from the start of the media is required
+type jsonChatEntry struct {
+	Time     time.Time `json:"time"`
+	Offset   float64   `json:"offset"`
+	Username string    `json:"username"`
+	Text     string    `json:"text"`
+}
+
+// loadChatLog reads a JSON / JSONL chat log or a raw IRC log with tmi-sent-ts tags, sorted by time
+func loadChatLog(path string, start time.Time) ([]chatEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat log: %w", err)
+	}
+
+	var entries []chatEntry
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".jsonl":
+		entries, err = parseJSONChatLog(data, start)
+	default:
+		entries, err = parseIRCChatLog(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	return entries, nil
+}
+
+func parseJSONChatLog(data []byte, start time.Time) ([]chatEntry, error) {
+	var raw []jsonChatEntry
+
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("[")) {
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, fmt.Errorf("failed to decode chat log: %w", err)
+		}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for decoder.More() {
+			var entry jsonChatEntry
+			if err := decoder.Decode(&entry); err != nil {
+				return nil, fmt.Errorf("failed to decode chat log entry %d: %w", len(raw)+1, err)
+			}
+			raw = append(raw, entry)
+		}
+	}
+
+	entries := make([]chatEntry, 0, len(raw))
+	for _, entry := range raw {
+		if entry.Username == "" || entry.Text == "" {
+			continue
+		}
+
+		timestamp := entry.Time
+		if timestamp.IsZero() {
+			timestamp = start.Add(time.Duration(entry.Offset * float64(time.Second)))
+		}
+
+		entries = append(entries, chatEntry{
+			Time: timestamp,
+			Message: queue.Message{
+				Source:      queue.SourceChat,
+				Username:    strings.ToLower(entry.Username),
+				DisplayName: entry.Username,
+				Text:        strings.TrimSpace(entry.Text),
+			},
+		})
+	}
+
+	return entries, nil
+}
+
+func parseIRCChatLog(data []byte) ([]chatEntry, error) {
+	var entries []chatEntry
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		message, ok := irc.ParseMessage(line).(*irc.PrivateMessage)
+		if !ok || message.Time.IsZero() {
+			continue
+		}
+
+		entries = append(entries, chatEntry{
+			Time:    message.Time,
+			Message: queue.NewChatMessage(*message),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read chat log: %w", err)
+	}
+
+	return entries, nil
+}
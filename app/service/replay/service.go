@@ -0,0 +1,236 @@
+package replay
+
+import (
+	"context"
+	"durkalive/app/client/twitch"
+	"durkalive/app/client/twitch_live"
+	"durkalive/app/config"
+	"durkalive/app/service/conversation"
+	"durkalive/app/service/engine"
+	"durkalive/app/service/queue"
+	"durkalive/app/service/transcribe"
+	"durkalive/app/util/clock"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/samber/do"
+)
+
+// Options of a replay run
+type Options struct {
+	// Channel whose persona is used, the memory comes from the scratch database
+	Channel string
+	VodID   string
+	File    string
+	// ChatLog is an optional JSON / JSONL or raw IRC chat log
+	ChatLog string
+	// Start is the wall time of the beginning of the media, used to align the chat log with a local file
+	Start time.Time
+	// Speed of the replay relative to real time
+	Speed float64
+	// Output is the JSONL file with the replies
+	Output string
+	// Database replaces the configured database name, replays never write to the live one
+	Database string
+}
+
+// ParseOptions parses the arguments of the replay command
+func ParseOptions(cfg *config.Config, args []string) (*Options, error) {
+	var (
+		opts  Options
+		start string
+	)
+
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.StringVar(&opts.Channel, "channel", cfg.Twitch.Channels[0].Name, "configured channel whose persona is used")
+	flags.StringVar(&opts.VodID, "vod", "", "twitch VOD id")
+	flags.StringVar(&opts.File, "file", "", "local audio or video file")
+	flags.StringVar(&opts.ChatLog, "chat", "", "chat log, JSON / JSONL or raw IRC lines with tmi-sent-ts tags")
+	flags.StringVar(&start, "start", "", "RFC3339 wall time of the media start, defaults to the VOD creation time or now")
+	flags.Float64Var(&opts.Speed, "speed", 1, "replay speed relative to real time")
+	flags.StringVar(&opts.Output, "out", "replay.jsonl", "output file with the replies")
+	flags.StringVar(&opts.Database, "db", "", "scratch database name, required to keep replayed messages and facts out of the live one")
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if (opts.VodID == "") == (opts.File == "") {
+		return nil, fmt.Errorf("exactly one of -vod and -file is required")
+	}
+
+	if opts.Database == "" || opts.Database == cfg.DB.Database {
+		return nil, fmt.Errorf("-db with a database other than the live %q is required", cfg.DB.Database)
+	}
+
+	if opts.Speed <= 0 {
+		return nil, fmt.Errorf("speed must be positive")
+	}
+
+	if !slices.ContainsFunc(cfg.Twitch.Channels, func(channel config.Channel) bool {
+		return channel.Name == opts.Channel
+	}) {
+		return nil, fmt.Errorf("channel %q is not configured", opts.Channel)
+	}
+
+	if start != "" {
+		startTime, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start time: %w", err)
+		}
+		opts.Start = startTime
+	}
+
+	return &opts, nil
+}
+
+// Service runs the pipeline against a VOD or a local file on an accelerated clock and records what the bot would say
+type Service struct {
+	cfg             *config.Config
+	twitchClient    *twitch.Client
+	liveClient      *twitch_live.Client
+	engineSvc       *engine.Service
+	conversationSvc *conversation.Service
+	queueSvc        *queue.Service
+	clock           *clock.Accelerated
+}
+
+type replyRecord struct {
+	Time time.Time `json:"time"`
+	// Offset from the start of the media
+	Offset   string `json:"offset"`
+	Channel  string `json:"channel"`
+	Username string `json:"username"`
+	Message  string `json:"message"`
+	Reply    string `json:"reply"`
+}
+
+func New(di *do.Injector) (*Service, error) {
+	return &Service{
+		cfg:             do.MustInvoke[*config.Config](di),
+		twitchClient:    do.MustInvoke[*twitch.Client](di),
+		liveClient:      do.MustInvoke[*twitch_live.Client](di),
+		engineSvc:       do.MustInvoke[*engine.Service](di),
+		conversationSvc: do.MustInvoke[*conversation.Service](di),
+		queueSvc:        do.MustInvoke[*queue.Service](di),
+		clock:           do.MustInvoke[*clock.Accelerated](di),
+	}, nil
+}
+
+func (s *Service) Run(ctx context.Context, opts Options) error {
+	source := transcribe.Source{
+		File:   opts.File,
+		Speed:  opts.Speed,
+		Replay: true,
+	}
+
+	start := opts.Start
+
+	if opts.VodID != "" {
+		playlist, err := s.liveClient.GetVodM3U8(ctx, opts.VodID)
+		if err != nil {
+			return fmt.Errorf("could not get VOD qualities: %w", err)
+		}
+
+		source.Playlist = engine.SelectQuality(playlist.Qualities, s.cfg.Twitch.Qualities).URL
+
+		if start.IsZero() {
+			createdAt, err := s.twitchClient.GetVideoCreatedAt(opts.VodID)
+			if err != nil {
+				slog.Warn("Could not get VOD creation time", "error", err)
+			}
+			start = createdAt
+		}
+	}
+
+	if start.IsZero() {
+		start = time.Now()
+	}
+
+	var chat []chatEntry
+	if opts.ChatLog != "" {
+		var err error
+		if chat, err = loadChatLog(opts.ChatLog, start); err != nil {
+			return err
+		}
+	}
+
+	output, err := os.Create(opts.Output)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer output.Close()
+
+	var outputMu sync.Mutex
+	encoder := json.NewEncoder(output)
+
+	s.conversationSvc.SetReplyListener(func(channel, username, text, reply string, at time.Time) {
+		outputMu.Lock()
+		defer outputMu.Unlock()
+
+		err := encoder.Encode(replyRecord{
+			Time:     at,
+			Offset:   at.Sub(start).Round(time.Second).String(),
+			Channel:  channel,
+			Username: username,
+			Message:  text,
+			Reply:    reply,
+		})
+		if err != nil {
+			slog.Error("Failed to write replay output", "error", err)
+		}
+	})
+
+	if err = s.conversationSvc.BeginStream(ctx, opts.Channel, time.Time{}); err != nil {
+		return fmt.Errorf("could not begin stream: %w", err)
+	}
+
+	slog.Info("Starting replay",
+		"channel", opts.Channel,
+		"vod", opts.VodID,
+		"file", opts.File,
+		"chat_messages", len(chat),
+		"start", start,
+		"speed", opts.Speed)
+
+	s.clock.Reset(start)
+
+	pipelineCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go s.feedChat(pipelineCtx, opts.Channel, chat)
+
+	// the pipeline always stops with an error, the end of the media included
+	reason := s.engineSvc.RunPipeline(pipelineCtx, opts.Channel, source)
+	s.conversationSvc.WaitReplies()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	slog.Info("Replay finished", "reason", reason, "duration", s.clock.Now().Sub(start).Round(time.Second))
+
+	return nil
+}
+
+// feedChat pushes the chat log messages into the queue when the clock reaches them
+func (s *Service) feedChat(ctx context.Context, channel string, chat []chatEntry) {
+	for _, entry := range chat {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.clock.Until(entry.Time)):
+		}
+
+		// the pipeline may fall behind the accelerated clock, the feed waits for it instead of dropping messages
+		if err := s.queueSvc.AddWait(ctx, channel, entry.Message); err != nil {
+			return
+		}
+	}
+}
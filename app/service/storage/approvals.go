@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Approval is the operator verdict on a generated reply, kept for prompt tuning
+type Approval struct {
+	MessageID int64
+	Channel   string
+	// Reply is the generated text
+	Reply   string
+	Outcome string
+	// FinalText is what was posted, empty if nothing was
+	FinalText string
+	Operator  string
+	Latency   time.Duration
+}
+
+func (s *Service) InsertApproval(ctx context.Context, approval Approval) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO reply_approvals (message_id, channel, reply, outcome, final_text, operator, latency_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		approval.MessageID,
+		approval.Channel,
+		approval.Reply,
+		approval.Outcome,
+		approval.FinalText,
+		approval.Operator,
+		approval.Latency.Milliseconds(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert approval: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const factColumns = "id, channel, subject, text, created_at, embedding, source_message_id, source_username, model, expires_at"
+
+type Fact struct {
+	ID int64
+	// Channel is the memory namespace of the fact
+	Channel string
+	// Subject is either "streamer", "channel" or "@<login>" of a viewer
+	Subject   string
+	Text      string
+	CreatedAt time.Time
+	// Embedding is nil until the fact is embedded
+	Embedding []float32
+	// SourceMessageID is the message that triggered the fact, nil if unknown
+	SourceMessageID *int64
+	// SourceUsername is the author of the source message
+	SourceUsername string
+	// Model that wrote the fact
+	Model string
+	// ExpiresAt is nil for permanent facts
+	ExpiresAt *time.Time
+}
+
+func scanFact(row pgx.Row) (Fact, error) {
+	var fact Fact
+
+	err := row.Scan(
+		&fact.ID,
+		&fact.Channel,
+		&fact.Subject,
+		&fact.Text,
+		&fact.CreatedAt,
+		&fact.Embedding,
+		&fact.SourceMessageID,
+		&fact.SourceUsername,
+		&fact.Model,
+		&fact.ExpiresAt,
+	)
+
+	return fact, err
+}
+
+func (s *Service) ListFacts(ctx context.Context) ([]Fact, error) {
+	rows, err := s.pool.Query(ctx, "SELECT "+factColumns+" FROM facts ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query facts: %w", err)
+	}
+
+	facts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Fact, error) {
+		return scanFact(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan facts: %w", err)
+	}
+
+	return facts, nil
+}
+
+// InsertFacts stores the new facts with their CreatedAt, silently skipping the ones that already exist
+func (s *Service) InsertFacts(ctx context.Context, facts []Fact) ([]Fact, error) {
+	result := make([]Fact, 0, len(facts))
+
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		for _, newFact := range facts {
+			fact, err := scanFact(tx.QueryRow(ctx, `
+				INSERT INTO facts (channel, subject, text, created_at, embedding, source_message_id, source_username, model, expires_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+				ON CONFLICT (channel, subject, text) DO NOTHING
+				RETURNING `+factColumns,
+				newFact.Channel,
+				newFact.Subject,
+				newFact.Text,
+				newFact.CreatedAt,
+				newFact.Embedding,
+				newFact.SourceMessageID,
+				newFact.SourceUsername,
+				newFact.Model,
+				newFact.ExpiresAt,
+			))
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to insert fact: %w", err)
+			}
+
+			result = append(result, fact)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ClaimOrphanFacts moves facts without a channel to the given channel
+func (s *Service) ClaimOrphanFacts(ctx context.Context, channel string) (int64, error) {
+	tag, err := s.pool.Exec(ctx, "UPDATE facts SET channel = $1 WHERE channel = ''", channel)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim orphan facts: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func (s *Service) DeleteFacts(ctx context.Context, ids []int64) error {
+	if _, err := s.pool.Exec(ctx, "DELETE FROM facts WHERE id = ANY($1)", ids); err != nil {
+		return fmt.Errorf("failed to delete facts: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredFacts removes facts whose TTL has passed and returns their ids
+func (s *Service) DeleteExpiredFacts(ctx context.Context, now time.Time) ([]int64, error) {
+	rows, err := s.pool.Query(ctx, "DELETE FROM facts WHERE expires_at <= $1 RETURNING id", now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired facts: %w", err)
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan expired facts: %w", err)
+	}
+
+	return ids, nil
+}
+
+func (s *Service) SetFactEmbedding(ctx context.Context, id int64, embedding []float32) error {
+	if _, err := s.pool.Exec(ctx, "UPDATE facts SET embedding = $2 WHERE id = $1", id, embedding); err != nil {
+		return fmt.Errorf("failed to update fact embedding: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type HistoryEntry struct {
+	Username  string
+	Text      string
+	Timestamp time.Time
+	// Source is empty for the bot replies
+	Source string
+}
+
+type Message struct {
+	Channel  string
+	Username string
+	Text     string
+	// Source is chat or speech
+	Source string
+	// TwitchID is the id of a chat message, empty for speech
+	TwitchID string
+}
+
+func (s *Service) InsertMessage(ctx context.Context, msg Message) (int64, error) {
+	var id int64
+
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO messages (channel, username, text, source, twitch_id) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`, msg.Channel, msg.Username, msg.Text, msg.Source, msg.TwitchID).
+		Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert message: %w", err)
+	}
+
+	return id, nil
+}
+
+type Decision struct {
+	MessageID int64
+	// NeedResponse, Confidence and Reason are the model verdict
+	NeedResponse bool
+	// Confidence is nil if the model left it out
+	Confidence *float64
+	Reason     string
+	// Respond is the final verdict after the code-side checks
+	Respond bool
+	// SkipReason explains why the code-side checks rejected the reply
+	SkipReason string
+	// Result is the full parsed decision agent output, stored as JSONB
+	Result any
+}
+
+func (s *Service) InsertDecision(ctx context.Context, decision Decision) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO decisions (message_id, need_response, confidence, reason, respond, skip_reason, result)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		decision.MessageID,
+		decision.NeedResponse,
+		decision.Confidence,
+		decision.Reason,
+		decision.Respond,
+		decision.SkipReason,
+		decision.Result,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert decision: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Service) InsertReply(ctx context.Context, channel string, messageID int64, username, text string) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO replies (message_id, channel, username, text) VALUES ($1, $2, $3, $4)`,
+		messageID, channel, username, text)
+	if err != nil {
+		return fmt.Errorf("failed to insert reply: %w", err)
+	}
+
+	return nil
+}
+
+// RecentHistory returns the last messages and bot replies of the channel in chronological order
+func (s *Service) RecentHistory(ctx context.Context, channel string, limit int) ([]HistoryEntry, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT username, text, created_at, source FROM (
+			SELECT username, text, created_at, source FROM messages WHERE channel = $1
+			UNION ALL
+			SELECT username, text, created_at, '' FROM replies WHERE channel = $1
+			ORDER BY created_at DESC
+			LIMIT $2
+		) recent
+		ORDER BY created_at`, channel, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (HistoryEntry, error) {
+		var entry HistoryEntry
+		err := row.Scan(&entry.Username, &entry.Text, &entry.Timestamp, &entry.Source)
+		return entry, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan history: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,36 @@
+CREATE TABLE facts (
+    id         BIGSERIAL PRIMARY KEY,
+    text       TEXT        NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE messages (
+    id         BIGSERIAL PRIMARY KEY,
+    channel    TEXT        NOT NULL,
+    username   TEXT        NOT NULL,
+    text       TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX messages_channel_created_at_idx ON messages (channel, created_at DESC);
+
+CREATE TABLE decisions (
+    id            BIGSERIAL PRIMARY KEY,
+    message_id    BIGINT      NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    need_response BOOLEAN     NOT NULL,
+    result        JSONB       NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX decisions_message_id_idx ON decisions (message_id);
+
+CREATE TABLE replies (
+    id         BIGSERIAL PRIMARY KEY,
+    message_id BIGINT REFERENCES messages (id) ON DELETE SET NULL,
+    channel    TEXT        NOT NULL,
+    username   TEXT        NOT NULL,
+    text       TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX replies_channel_created_at_idx ON replies (channel, created_at DESC);
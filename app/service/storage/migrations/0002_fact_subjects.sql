@@ -0,0 +1,5 @@
+ALTER TABLE facts ADD COLUMN subject TEXT NOT NULL DEFAULT 'channel';
+
+ALTER TABLE facts DROP CONSTRAINT facts_text_key;
+
+CREATE UNIQUE INDEX facts_subject_text_idx ON facts (subject, text);
@@ -0,0 +1 @@
+ALTER TABLE facts ADD COLUMN embedding REAL[];
@@ -0,0 +1,7 @@
+ALTER TABLE facts
+    ADD COLUMN source_message_id BIGINT REFERENCES messages (id) ON DELETE SET NULL,
+    ADD COLUMN source_username   TEXT NOT NULL DEFAULT '',
+    ADD COLUMN model             TEXT NOT NULL DEFAULT '',
+    ADD COLUMN expires_at        TIMESTAMPTZ;
+
+CREATE INDEX facts_expires_at_idx ON facts (expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,7 @@
+CREATE TABLE summaries (
+    channel           TEXT        NOT NULL,
+    stream_started_at TIMESTAMPTZ NOT NULL,
+    text              TEXT        NOT NULL,
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (channel, stream_started_at)
+);
@@ -0,0 +1,4 @@
+ALTER TABLE decisions
+    ADD COLUMN confidence REAL NOT NULL DEFAULT 0,
+    ADD COLUMN reason     TEXT NOT NULL DEFAULT '',
+    ADD COLUMN respond    BOOLEAN NOT NULL DEFAULT FALSE;
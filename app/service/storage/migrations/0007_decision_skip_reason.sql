@@ -0,0 +1 @@
+ALTER TABLE decisions ADD COLUMN skip_reason TEXT NOT NULL DEFAULT '';
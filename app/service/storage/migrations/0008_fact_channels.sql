@@ -0,0 +1,6 @@
+-- facts learned before multi-channel support are claimed by the first configured channel on startup
+ALTER TABLE facts ADD COLUMN channel TEXT NOT NULL DEFAULT '';
+
+DROP INDEX facts_subject_text_idx;
+
+CREATE UNIQUE INDEX facts_channel_subject_text_idx ON facts (channel, subject, text);
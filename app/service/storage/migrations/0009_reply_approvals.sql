@@ -0,0 +1,13 @@
+CREATE TABLE reply_approvals (
+    id         BIGSERIAL PRIMARY KEY,
+    message_id BIGINT REFERENCES messages (id) ON DELETE SET NULL,
+    channel    TEXT        NOT NULL,
+    reply      TEXT        NOT NULL,
+    outcome    TEXT        NOT NULL,
+    final_text TEXT        NOT NULL DEFAULT '',
+    operator   TEXT        NOT NULL DEFAULT '',
+    latency_ms BIGINT      NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX reply_approvals_channel_created_at_idx ON reply_approvals (channel, created_at DESC);
@@ -0,0 +1,2 @@
+ALTER TABLE messages ADD COLUMN source TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN twitch_id TEXT NOT NULL DEFAULT '';
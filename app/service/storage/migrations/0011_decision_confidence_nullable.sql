@@ -0,0 +1,4 @@
+-- NULL when the model left the confidence out
+ALTER TABLE decisions
+    ALTER COLUMN confidence DROP NOT NULL,
+    ALTER COLUMN confidence DROP DEFAULT;
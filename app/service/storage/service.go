@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"durkalive/app/config"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samber/do"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+var _ do.Shutdownable = (*Service)(nil)
+
+type Service struct {
+	cfg  *config.Config
+	pool *pgxpool.Pool
+}
+
+func New(di *do.Injector) (*Service, error) {
+	ctx := do.MustInvoke[context.Context](di)
+	cfg := do.MustInvoke[*config.Config](di)
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.DB.User, cfg.DB.Pass),
+		Host:     cfg.DB.Host,
+		Path:     cfg.DB.Database,
+		RawQuery: "sslmode=disable",
+	}
+
+	pool, err := pgxpool.New(ctx, dsn.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create postgres pool: %w", err)
+	}
+
+	if err = pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+
+	s := &Service{
+		cfg:  cfg,
+		pool: pool,
+	}
+
+	if err = s.migrate(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to apply migrations: %w", err)
+	}
+
+	return s, nil
+}
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations dir: %w", err)
+	}
+
+	result := make([]migration, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+
+		versionStr, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration name %q", name)
+		}
+
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q: %w", name, err)
+		}
+
+		data, err := migrationsFS.ReadFile("migrations/" + name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %w", name, err)
+		}
+
+		result = append(result, migration{
+			version: version,
+			name:    name,
+			sql:     string(data),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].version < result[j].version
+	})
+
+	return result, nil
+}
+
+func (s *Service) migrate(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	for _, m := range migrations {
+		if err = s.applyMigration(ctx, m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) applyMigration(ctx context.Context, m migration) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		// serializes concurrent instances applying the same migrations
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('durkalive_migrations'))"); err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+
+		var applied bool
+		err := tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version = $1)", m.version).
+			Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("failed to check migration state: %w", err)
+		}
+		if applied {
+			return nil
+		}
+
+		if _, err = tx.Exec(ctx, m.sql); err != nil {
+			return fmt.Errorf("failed to execute: %w", err)
+		}
+
+		if _, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", m.version); err != nil {
+			return fmt.Errorf("failed to record migration: %w", err)
+		}
+
+		slog.Info("Applied migration", "name", m.name)
+
+		return nil
+	})
+}
+
+func (s *Service) Shutdown() error {
+	s.pool.Close()
+	return nil
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// GetSummary returns the conversation summary of the stream or an empty string if there is none yet
+func (s *Service) GetSummary(ctx context.Context, channel string, streamStartedAt time.Time) (string, error) {
+	var text string
+
+	err := s.pool.QueryRow(ctx, `
+		SELECT text FROM summaries WHERE channel = $1 AND stream_started_at = $2`,
+		channel, streamStartedAt).
+		Scan(&text)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query summary: %w", err)
+	}
+
+	return text, nil
+}
+
+func (s *Service) SaveSummary(ctx context.Context, channel string, streamStartedAt time.Time, text string) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO summaries (channel, stream_started_at, text) VALUES ($1, $2, $3)
+		ON CONFLICT (channel, stream_started_at) DO UPDATE SET text = excluded.text, updated_at = now()`,
+		channel, streamStartedAt, text)
+	if err != nil {
+		return fmt.Errorf("failed to save summary: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package streaminfo
+
+import (
+	"context"
+	"durkalive/app/client/twitch"
+	"durkalive/app/config"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/samber/do"
+)
+
+// Info is the cached state of a live stream
+type Info struct {
+	Title   string
+	Game    string
+	Tags    []string
+	Viewers int
+	// StartedAt is zero while the channel is offline
+	StartedAt time.Time
+	// UpdatedAt is the time of the last refresh
+	UpdatedAt time.Time
+}
+
+func (i Info) Live() bool {
+	return !i.StartedAt.IsZero()
+}
+
+// Service periodically caches the title, category, tags and viewer count of the channels
+type Service struct {
+	cfg          *config.Config
+	twitchClient *twitch.Client
+
+	mu    sync.RWMutex
+	infos map[string]Info
+}
+
+func New(di *do.Injector) (*Service, error) {
+	return &Service{
+		cfg:          do.MustInvoke[*config.Config](di),
+		twitchClient: do.MustInvoke[*twitch.Client](di),
+		infos:        make(map[string]Info),
+	}, nil
+}
+
+// Get returns the cached info of the channel, zero if the channel is offline or not fetched yet
+func (s *Service) Get(channel string) Info {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.infos[channel]
+}
+
+// UpdateChannel applies a title and category change reported by eventsub before the next refresh
+func (s *Service) UpdateChannel(channel, title, game string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.infos[channel]
+	if !ok {
+		return
+	}
+
+	info.Title = title
+	info.Game = game
+	s.infos[channel] = info
+}
+
+func (s *Service) RunLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Twitch.StreamInfoInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, channel := range s.cfg.Twitch.Channels {
+			s.refresh(channel.Name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) refresh(channel string) {
+	stream, err := s.twitchClient.GetStream(channel)
+	if errors.Is(err, twitch.ErrStreamOffline) {
+		s.mu.Lock()
+		delete(s.infos, channel)
+		s.mu.Unlock()
+		return
+	}
+	if err != nil {
+		// the previous info stays until the next refresh
+		slog.Warn("Failed to refresh stream info", "channel", channel, "error", err)
+		return
+	}
+
+	info := Info{
+		Title:     stream.Title,
+		Game:      stream.GameName,
+		Tags:      stream.Tags,
+		Viewers:   stream.ViewerCount,
+		StartedAt: stream.StartedAt,
+		UpdatedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	s.infos[channel] = info
+	s.mu.Unlock()
+}
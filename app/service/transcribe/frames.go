@@ -0,0 +1,124 @@
+package transcribe
+
+import (
+	"bufio"
+	"bytes"
+	"durkalive/app/client/stt"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	frameDuration = 100 * time.Millisecond
+	frameSize     = int(stt.SampleRate*frameDuration/time.Second) * stt.BytesPerSample
+
+	// size of a wav chunk header larger than this means a broken stream, not a real chunk
+	maxWAVChunkSize = 1 << 20
+)
+
+// FrameReader splits a WAV stream into raw s16le mono PCM frames of frameDuration.
+// The RIFF/WAVE header at the start of the stream is validated and skipped, the frames start on a sample boundary.
+type FrameReader struct {
+	src        *bufio.Reader
+	headerRead bool
+}
+
+func NewFrameReader(src io.Reader) *FrameReader {
+	return &FrameReader{
+		src: bufio.NewReader(src),
+	}
+}
+
+// ReadFrame returns the next whole frame, a trailing partial frame is discarded
+func (r *FrameReader) ReadFrame() ([]byte, error) {
+	if !r.headerRead {
+		if err := r.readWAVHeader(); err != nil {
+			return nil, err
+		}
+		r.headerRead = true
+	}
+
+	frame := make([]byte, frameSize)
+	if _, err := io.ReadFull(r.src, frame); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+func (r *FrameReader) readWAVHeader() error {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r.src, header); err != nil {
+		// no audio at all
+		if err == io.EOF {
+			return err
+		}
+		return fmt.Errorf("failed to read riff header: %w", err)
+	}
+	if !bytes.Equal(header[:4], []byte("RIFF")) {
+		return fmt.Errorf("audio stream is not wav, got %q", header[:4])
+	}
+	if !bytes.Equal(header[8:12], []byte("WAVE")) {
+		return fmt.Errorf("unsupported riff type %q", header[8:12])
+	}
+
+	chunkHeader := make([]byte, 8)
+	formatChecked := false
+
+	for {
+		if _, err := io.ReadFull(r.src, chunkHeader); err != nil {
+			return fmt.Errorf("failed to read wav chunk header: %w", err)
+		}
+
+		chunkID := string(chunkHeader[:4])
+		chunkSize := binary.LittleEndian.Uint32(chunkHeader[4:])
+
+		// the length of the data chunk is bogus on pipes, audio always follows it
+		if chunkID == "data" {
+			if !formatChecked {
+				return fmt.Errorf("wav data chunk comes before the format chunk")
+			}
+			return nil
+		}
+
+		if chunkSize > maxWAVChunkSize {
+			return fmt.Errorf("wav chunk %q is too large (%d bytes)", chunkID, chunkSize)
+		}
+
+		// chunks are padded to an even size
+		chunk := make([]byte, chunkSize+chunkSize%2)
+		if _, err := io.ReadFull(r.src, chunk); err != nil {
+			return fmt.Errorf("failed to read wav chunk %q: %w", chunkID, err)
+		}
+
+		if chunkID == "fmt " {
+			if err := validateWAVFormat(chunk); err != nil {
+				return err
+			}
+			formatChecked = true
+		}
+	}
+}
+
+func validateWAVFormat(chunk []byte) error {
+	if len(chunk) < 16 {
+		return fmt.Errorf("wav format chunk is too short (%d bytes)", len(chunk))
+	}
+
+	audioFormat := binary.LittleEndian.Uint16(chunk[0:])
+	channels := binary.LittleEndian.Uint16(chunk[2:])
+	sampleRate := binary.LittleEndian.Uint32(chunk[4:])
+	bitsPerSample := binary.LittleEndian.Uint16(chunk[14:])
+
+	if audioFormat != 1 || channels != 1 || sampleRate != stt.SampleRate || bitsPerSample != stt.BytesPerSample*8 {
+		return fmt.Errorf("unsupported wav format: format=%d channels=%d rate=%d bits=%d",
+			audioFormat, channels, sampleRate, bitsPerSample)
+	}
+
+	return nil
+}
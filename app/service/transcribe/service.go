@@ -3,50 +3,145 @@ package transcribe
 import (
 	"context"
 	"durkalive/app/client/speechkit"
+	"durkalive/app/client/stt"
 	"durkalive/app/client/twitch_irc"
+	"durkalive/app/client/twitch_live"
+	"durkalive/app/client/whisper"
 	"durkalive/app/config"
 	"durkalive/app/service/queue"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	irc "github.com/gempir/go-twitch-irc/v4"
 	"github.com/samber/do"
 	"golang.org/x/sync/errgroup"
 )
 
 const (
-	bufferSize = 4096
+	chatReconnectDelay = 5 * time.Second
+	audioStatsInterval = 5 * time.Minute
 )
 
+// errAudioEnded is not io.EOF so that the end of the audio is not taken for the end of an STT session
+var errAudioEnded = errors.New("audio stream ended")
+
 type Service struct {
 	cfg          *config.Config
-	speechClient *speechkit.YandexSpeechKit
+	speechClient stt.Provider
 	ircClient    *twitch_irc.Client
+	liveClient   *twitch_live.Client
 	queue        *queue.Service
+
+	mu sync.RWMutex
+	// activeChannels are the channels with a running transcription, chat of other channels is ignored
+	activeChannels map[string]bool
 }
 
 func New(di *do.Injector) (*Service, error) {
+	cfg := do.MustInvoke[*config.Config](di)
+
+	var speechClient stt.Provider
+	switch cfg.STT.Provider {
+	case "whisper":
+		speechClient = do.MustInvoke[*whisper.Client](di)
+	default:
+		speechClient = do.MustInvoke[*speechkit.YandexSpeechKit](di)
+	}
+
 	return &Service{
-		cfg:          do.MustInvoke[*config.Config](di),
-		speechClient: do.MustInvoke[*speechkit.YandexSpeechKit](di),
-		ircClient:    do.MustInvoke[*twitch_irc.Client](di),
-		queue:        do.MustInvoke[*queue.Service](di),
+		cfg:            cfg,
+		speechClient:   speechClient,
+		ircClient:      do.MustInvoke[*twitch_irc.Client](di),
+		liveClient:     do.MustInvoke[*twitch_live.Client](di),
+		queue:          do.MustInvoke[*queue.Service](di),
+		activeChannels: make(map[string]bool),
 	}, nil
 }
 
-func (s *Service) Start(ctx context.Context, m3u8URL string) (context.Context, context.CancelCauseFunc) {
+// RunChat forwards chat messages of the active channels to their queues over a single IRC connection
+func (s *Service) RunChat(ctx context.Context) {
+	s.ircClient.SetListener(func(channel string, message irc.PrivateMessage) {
+		if s.cfg.Twitch.IgnoreChat || !s.isActive(channel) {
+			return
+		}
+
+		s.queue.Add(channel, queue.NewChatMessage(message))
+	})
+
+	for _, channel := range s.cfg.Twitch.Channels {
+		s.ircClient.JoinChannel(channel.Name)
+	}
+
+	go func() {
+		<-ctx.Done()
+		s.ircClient.Disconnect()
+	}()
+
+	for {
+		err := s.ircClient.Run()
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.Error("IRC connection failed", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(chatReconnectDelay):
+		}
+	}
+}
+
+func (s *Service) isActive(channel string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.activeChannels[channel]
+}
+
+func (s *Service) setActive(channel string, active bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if active {
+		s.activeChannels[channel] = true
+	} else {
+		delete(s.activeChannels, channel)
+	}
+}
+
+// Source is the audio of a transcription, either an HLS media playlist or a local media file
+type Source struct {
+	Playlist string
+	File     string
+	// Speed limits the reading to the given multiple of real time, zero means as fast as the source goes
+	Speed float64
+	// Replay waits for room in the queue instead of dropping phrases
+	Replay bool
+}
+
+// Start transcribes the source until it ends, the returned context is canceled with the reason of the stop
+func (s *Service) Start(ctx context.Context, channel string, source Source) (context.Context, context.CancelCauseFunc) {
 	ctx, cancel := context.WithCancelCause(ctx)
 
-	go s.runTranscription(ctx, cancel, m3u8URL)
+	go s.runTranscription(ctx, cancel, channel, source)
 
 	return ctx, cancel
 }
 
-func (s *Service) runTranscription(ctx context.Context, cancel context.CancelCauseFunc, m3u8URL string) {
+func (s *Service) runTranscription(ctx context.Context, cancel context.CancelCauseFunc, channel string, source Source) {
 	defer cancel(nil)
 
-	ffmpeg, err := NewFFmpegStream(ctx, m3u8URL)
+	s.setActive(channel, true)
+	defer s.setActive(channel, false)
+
+	ffmpeg, err := NewFFmpegStream(ctx, source.File, source.Speed)
 	if err != nil {
 		cancel(fmt.Errorf("failed to create ffmpeg stream: %w", err))
 		return
@@ -56,56 +151,116 @@ func (s *Service) runTranscription(ctx context.Context, cancel context.CancelCau
 		cancel(fmt.Errorf("failed to start ffmpeg: %w", err))
 		return
 	}
-	defer ffmpeg.Stop()
-
-	audioStream := ffmpeg.GetAudioStream()
-
-	go func() {
-		cancel(s.runTranscriptionWithRetry(ctx, audioStream))
+	defer func() {
+		_ = ffmpeg.Stop()
+		_ = ffmpeg.Wait()
 	}()
 
 	go func() {
-		s.ircClient.JoinChannel(s.cfg.Twitch.Channel)
-		s.ircClient.SetListener(func(channel, username, messageID, text string, tags map[string]string) {
-			if s.cfg.Twitch.IgnoreChat {
-				return
-			}
+		input := ffmpeg.GetInput()
+		defer input.Close()
 
-			s.queue.Add(username, text)
+		if source.Playlist == "" {
+			return
+		}
+
+		err := s.liveClient.StreamHLS(ctx, source.Playlist, input, func(active bool) {
+			slog.Info("Ad break", "channel", channel, "active", active)
+
+			if active {
+				s.queue.AddEvent(channel, queue.EventAdBreakStarted, queue.EventDetails{})
+			} else {
+				s.queue.AddEvent(channel, queue.EventAdBreakEnded, queue.EventDetails{})
+			}
 		})
 
-		cancel(s.ircClient.Run())
+		// ffmpeg exits by itself once it decodes the rest of the input
+		if !errors.Is(err, twitch_live.ErrStreamEnded) {
+			cancel(fmt.Errorf("hls stream stopped: %w", err))
+		}
 	}()
-	defer s.ircClient.Disconnect()
+
+	frames := NewFrameReader(ffmpeg.GetAudioStream())
+	vad := newVoiceDetector(s.cfg.STT.VAD)
+	defer logAudioStats(channel, vad)
 
 	go func() {
-		err := ffmpeg.Wait()
-		if err == nil {
-			err = fmt.Errorf("ffmpeg process finished")
+		err := s.runTranscriptionWithRetry(ctx, channel, frames, vad, source.Replay)
+
+		// ffmpeg closes its output when it exits, the pipe must be read to the end before waiting for it
+		if errors.Is(err, errAudioEnded) {
+			if waitErr := ffmpeg.Wait(); waitErr != nil {
+				err = fmt.Errorf("ffmpeg failed: %w", waitErr)
+			}
 		}
+
 		cancel(err)
 	}()
 
+	go func() {
+		ticker := time.NewTicker(audioStatsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				logAudioStats(channel, vad)
+			}
+		}
+	}()
+
 	<-ctx.Done()
 
-	if err = context.Cause(ctx); err != nil && !errors.Is(err, context.Canceled) {
-		slog.Error("Transcription failed", "error", err)
+	err = context.Cause(ctx)
+
+	switch {
+	case errors.Is(err, errAudioEnded):
+		slog.Info("Transcription finished", "channel", channel)
+	case err != nil && !errors.Is(err, context.Canceled):
+		slog.Error("Transcription failed", "channel", channel, "error", err)
+	}
+}
+
+func logAudioStats(channel string, vad *voiceDetector) {
+	sent, skipped := vad.stats()
+
+	var sentRatio float64
+	if total := sent + skipped; total > 0 {
+		sentRatio = float64(sent) / float64(total)
 	}
+
+	slog.Info("Audio stats",
+		"channel", channel,
+		"sent", sent,
+		"skipped", skipped,
+		"sent_ratio", sentRatio)
 }
 
-func (s *Service) runTranscriptionWithRetry(ctx context.Context, audioSrc io.Reader) error {
+func (s *Service) runTranscriptionWithRetry(
+	ctx context.Context,
+	channel string,
+	frames *FrameReader,
+	vad *voiceDetector,
+	replay bool,
+) error {
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			err := s.runSingleTranscription(ctx, audioSrc)
+			err := s.runSingleTranscription(ctx, channel, frames, vad, replay)
 			if err == nil {
 				return nil
 			}
 
+			if errors.Is(err, errAudioEnded) {
+				return err
+			}
+
 			if errors.Is(err, io.EOF) {
-				slog.Info("received EOF from speechkit, restarting speechClient")
+				slog.Info("Speech to text session ended, restarting", "channel", channel)
 				continue
 			}
 
@@ -114,55 +269,86 @@ func (s *Service) runTranscriptionWithRetry(ctx context.Context, audioSrc io.Rea
 	}
 }
 
-func (s *Service) runSingleTranscription(ctx context.Context, audioSrc io.Reader) error {
+// runSingleTranscription runs one STT session, sessions always start on a frame boundary.
+// At the end of the audio the session is given the time to recognize the rest of it and errAudioEnded is returned.
+func (s *Service) runSingleTranscription(
+	ctx context.Context,
+	channel string,
+	frames *FrameReader,
+	vad *voiceDetector,
+	replay bool,
+) error {
 	handle, err := s.speechClient.Start(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to start transcription: %w", err)
 	}
 	defer handle.Close()
 
+	var audioEnded atomic.Bool
+
 	g, ctx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
-		return s.streamAudio(ctx, audioSrc, handle)
+		err := s.streamAudio(ctx, frames, vad, handle)
+		if !errors.Is(err, errAudioEnded) {
+			return err
+		}
+
+		audioEnded.Store(true)
+
+		if err = handle.CloseSend(); err != nil {
+			return fmt.Errorf("failed to close audio: %w", err)
+		}
+
+		return nil
 	})
 
 	g.Go(func() error {
-		return s.receivePhrases(ctx, handle)
+		err := s.receivePhrases(ctx, channel, handle, replay)
+		if errors.Is(err, io.EOF) && audioEnded.Load() {
+			return errAudioEnded
+		}
+
+		return err
 	})
 
 	return g.Wait()
 }
 
-func (s *Service) streamAudio(ctx context.Context, audioSrc io.Reader, handle *speechkit.Handle) error {
-	if err := handle.SendConfig(); err != nil {
-		return fmt.Errorf("failed to send audio config: %w", err)
-	}
-
-	buffer := make([]byte, bufferSize)
-
+func (s *Service) streamAudio(ctx context.Context, frames *FrameReader, vad *voiceDetector, handle stt.Session) error {
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			n, err := audioSrc.Read(buffer)
+			frame, err := frames.ReadFrame()
+			if errors.Is(err, io.EOF) {
+				return errAudioEnded
+			}
 			if err != nil {
 				return fmt.Errorf("failed to read audio: %w", err)
 			}
 
-			if n == 0 {
-				continue
+			speechFrames, closed := vad.process(frame)
+
+			// providers that split the audio on pauses would otherwise hold the last phrase until the next speech
+			if closed {
+				if err = handle.Flush(); err != nil {
+					return fmt.Errorf("failed to flush audio: %w", err)
+				}
 			}
 
-			if err = handle.Send(buffer[:n]); err != nil {
-				return fmt.Errorf("failed to send audio: %w", err)
+			for _, speechFrame := range speechFrames {
+				if err = handle.Send(speechFrame); err != nil {
+					return fmt.Errorf("failed to send audio: %w", err)
+				}
 			}
 		}
 	}
 }
 
-func (s *Service) receivePhrases(ctx context.Context, handle *speechkit.Handle) error {
+// receivePhrases pushes final phrases to the queue, replays wait for room in it instead of dropping them
+func (s *Service) receivePhrases(ctx context.Context, channel string, handle stt.Session, replay bool) error {
 	for {
 		select {
 		case <-ctx.Done():
@@ -170,13 +356,27 @@ func (s *Service) receivePhrases(ctx context.Context, handle *speechkit.Handle)
 		default:
 		}
 
-		sentences, err := handle.Recv()
+		phrases, err := handle.Recv()
 		if err != nil {
 			return fmt.Errorf("Recv: %w", err)
 		}
 
-		for _, text := range sentences {
-			s.queue.Add(s.cfg.Twitch.Channel, text)
+		for _, phrase := range phrases {
+			if !phrase.Final {
+				slog.Debug("Partial phrase", "channel", channel, "text", phrase.Text)
+				continue
+			}
+
+			msg := queue.NewSpeechMessage(channel, phrase.Text)
+
+			if !replay {
+				s.queue.Add(channel, msg)
+				continue
+			}
+
+			if err = s.queue.AddWait(ctx, channel, msg); err != nil {
+				return err
+			}
 		}
 	}
 }
@@ -3,40 +3,64 @@ package transcribe
 import (
 	"bufio"
 	"context"
+	"durkalive/app/client/stt"
 	"fmt"
 	"io"
 	"log/slog"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
 )
 
+// FFmpegStream decodes a media file or MPEG-TS written to its input into a WAV stream of the stt format
 type FFmpegStream struct {
 	cmd    *exec.Cmd
+	stdin  io.WriteCloser
 	stdout io.ReadCloser
 	stderr io.ReadCloser
 	mu     sync.Mutex
+
+	waitOnce sync.Once
+	waitErr  error
 }
 
-func NewFFmpegStream(ctx context.Context, m3u8URL string) (*FFmpegStream, error) {
+// NewFFmpegStream reads MPEG-TS from the input if file is empty.
+// Speed limits the reading to the given multiple of real time, zero means as fast as possible.
+func NewFFmpegStream(ctx context.Context, file string, speed float64) (*FFmpegStream, error) {
 	args := []string{
 		"-loglevel", "warning",
-		"-i", m3u8URL,
-		"-reconnect", "0",
-		"-reconnect_at_eof", "0",
-		"-reconnect_streamed", "0",
-		"-reconnect_delay_max", "0",
+		"-fflags", "+discardcorrupt",
+	}
+
+	if speed > 0 {
+		args = append(args, "-readrate", strconv.FormatFloat(speed, 'f', -1, 64))
+	}
+
+	if file == "" {
+		args = append(args, "-f", "mpegts", "-i", "pipe:0")
+	} else {
+		args = append(args, "-i", file)
+	}
+
+	args = append(args,
 		"-vn",
 		"-acodec", "pcm_s16le",
 		"-ac", "1",
-		"-ar", "16000",
+		"-ar", strconv.Itoa(stt.SampleRate),
+		// the header lets FrameReader check the format ffmpeg actually produced
 		"-f", "wav",
 		"-",
-	}
+	)
 
 	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 	slog.Info("Running ffmpeg", "cmd", "ffmpeg "+strings.Join(args, " "))
 
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
@@ -49,6 +73,7 @@ func NewFFmpegStream(ctx context.Context, m3u8URL string) (*FFmpegStream, error)
 
 	return &FFmpegStream{
 		cmd:    cmd,
+		stdin:  stdin,
 		stdout: stdout,
 		stderr: stderr,
 	}, nil
@@ -67,12 +92,21 @@ func (f *FFmpegStream) Start() error {
 	return nil
 }
 
+func (f *FFmpegStream) GetInput() io.WriteCloser {
+	return f.stdin
+}
+
 func (f *FFmpegStream) GetAudioStream() io.ReadCloser {
 	return f.stdout
 }
 
+// Wait waits for ffmpeg to exit, it may be called several times and from several goroutines
 func (f *FFmpegStream) Wait() error {
-	return f.cmd.Wait()
+	f.waitOnce.Do(func() {
+		f.waitErr = f.cmd.Wait()
+	})
+
+	return f.waitErr
 }
 
 func (f *FFmpegStream) Stop() error {
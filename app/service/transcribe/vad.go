@@ -0,0 +1,89 @@
+package transcribe
+
+import (
+	"durkalive/app/client/stt"
+	"durkalive/app/config"
+	"sync/atomic"
+	"time"
+)
+
+// voiceDetector is an energy based gate in front of the STT provider.
+// The gate opens after MinSpeech of continuous loud frames, sending PreRoll of audio before them,
+// and closes after Hangover of quiet frames.
+type voiceDetector struct {
+	cfg config.VAD
+
+	open      bool
+	voicedRun time.Duration
+	silence   time.Duration
+	// pending frames are held back while the gate is closed
+	pending    [][]byte
+	maxPending int
+
+	sentFrames    atomic.Int64
+	skippedFrames atomic.Int64
+}
+
+func newVoiceDetector(cfg config.VAD) *voiceDetector {
+	return &voiceDetector{
+		cfg:        cfg,
+		maxPending: int((cfg.PreRoll + cfg.MinSpeech + frameDuration - 1) / frameDuration),
+	}
+}
+
+// process returns the frames that should be sent to the provider, closed is set when the gate has just closed
+func (v *voiceDetector) process(frame []byte) (frames [][]byte, closed bool) {
+	if !v.cfg.Enabled {
+		v.sentFrames.Add(1)
+		return [][]byte{frame}, false
+	}
+
+	voiced := stt.RMS(frame) >= float64(v.cfg.Threshold)
+
+	if v.open {
+		if voiced {
+			v.silence = 0
+		} else {
+			v.silence += frameDuration
+		}
+
+		if v.silence <= v.cfg.Hangover {
+			v.sentFrames.Add(1)
+			return [][]byte{frame}, false
+		}
+
+		v.open = false
+		v.voicedRun = 0
+		closed = true
+	}
+
+	if voiced {
+		v.voicedRun += frameDuration
+	} else {
+		v.voicedRun = 0
+	}
+
+	v.pending = append(v.pending, frame)
+
+	if v.voicedRun >= v.cfg.MinSpeech {
+		result := v.pending
+		v.pending = nil
+		v.open = true
+		v.silence = 0
+		v.sentFrames.Add(int64(len(result)))
+		return result, closed
+	}
+
+	if len(v.pending) > v.maxPending {
+		dropped := len(v.pending) - v.maxPending
+		v.pending = v.pending[dropped:]
+		v.skippedFrames.Add(int64(dropped))
+	}
+
+	return nil, closed
+}
+
+// stats returns the duration of the audio sent to and kept from the provider
+func (v *voiceDetector) stats() (sent, skipped time.Duration) {
+	return time.Duration(v.sentFrames.Load()) * frameDuration, time.Duration(v.skippedFrames.Load()) * frameDuration
+}
@@ -0,0 +1,58 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of the current time for the conversation, replays run it faster than real time
+type Clock interface {
+	Now() time.Time
+}
+
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// Accelerated starts at a virtual time and runs speed times faster than real time
+type Accelerated struct {
+	mu        sync.RWMutex
+	start     time.Time
+	realStart time.Time
+	speed     float64
+}
+
+func NewAccelerated(start time.Time, speed float64) *Accelerated {
+	return &Accelerated{
+		start:     start,
+		realStart: time.Now(),
+		speed:     speed,
+	}
+}
+
+func (c *Accelerated) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	elapsed := time.Since(c.realStart)
+
+	return c.start.Add(time.Duration(float64(elapsed) * c.speed))
+}
+
+// Reset moves the clock to the given virtual time
+func (c *Accelerated) Reset(start time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.start = start
+	c.realStart = time.Now()
+}
+
+// Until returns the real time left until the clock reaches t
+func (c *Accelerated) Until(t time.Time) time.Duration {
+	left := t.Sub(c.Now())
+
+	return time.Duration(float64(left) / c.speed)
+}
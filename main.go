@@ -2,20 +2,29 @@ package main
 
 import (
 	"context"
+	"durkalive/app/client/embeddings"
+	"durkalive/app/client/eventsub"
 	"durkalive/app/client/speechkit"
 	"durkalive/app/client/twitch"
 	"durkalive/app/client/twitch_irc"
 	"durkalive/app/client/twitch_live"
+	"durkalive/app/client/whisper"
 	"durkalive/app/config"
+	"durkalive/app/service/approval"
 	"durkalive/app/service/conversation"
 	"durkalive/app/service/engine"
 	"durkalive/app/service/memory"
 	"durkalive/app/service/queue"
+	"durkalive/app/service/replay"
+	"durkalive/app/service/storage"
+	"durkalive/app/service/streaminfo"
 	"durkalive/app/service/transcribe"
+	"durkalive/app/util/clock"
 	"durkalive/app/util/mylog"
 	"log/slog"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/samber/do"
@@ -38,19 +47,47 @@ func main() {
 	}
 	do.ProvideValue(di, cfg)
 
+	var replayOpts *replay.Options
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replayOpts, err = replay.ParseOptions(cfg, os.Args[2:])
+		if err != nil {
+			log.Fatalf("invalid replay options: %v", err)
+		}
+
+		// replays never post to chat
+		cfg.Twitch.DisableNotifications = true
+		cfg.Conversation.Approval.Enabled = false
+		cfg.DB.Database = replayOpts.Database
+	}
+
 	if err = mylog.Init(cfg); err != nil {
 		log.Fatalf("logging init failed: %v", err)
 	}
 
+	if replayOpts != nil {
+		replayClock := clock.NewAccelerated(time.Now(), replayOpts.Speed)
+		do.ProvideValue(di, replayClock)
+		do.ProvideValue[clock.Clock](di, replayClock)
+	} else {
+		do.ProvideValue[clock.Clock](di, clock.Real{})
+	}
+
 	do.Provide(di, speechkit.NewClient)
+	do.Provide(di, whisper.NewClient)
+	do.Provide(di, embeddings.NewClient)
 	do.Provide(di, twitch.NewClient)
 	do.Provide(di, twitch_live.NewClient)
 	do.Provide(di, twitch_irc.NewClient)
+	do.Provide(di, eventsub.NewClient)
 	do.Provide(di, transcribe.New)
+	do.Provide(di, storage.New)
 	do.Provide(di, memory.New)
+	do.Provide(di, streaminfo.New)
+	do.Provide(di, approval.New)
 	do.Provide(di, conversation.New)
 	do.Provide(di, queue.New)
 	do.Provide(di, engine.New)
+	do.Provide(di, replay.New)
 
 	slog.Info("Service started")
 
@@ -64,8 +101,24 @@ func main() {
 		cancel()
 	}()
 
+	if replayOpts != nil {
+		go func() {
+			defer cancel()
+
+			if err := do.MustInvoke[*replay.Service](di).Run(appCtx, *replayOpts); err != nil {
+				slog.Error("Replay failed", "error", err)
+			}
+		}()
+
+		<-appCtx.Done()
+		return
+	}
+
 	go do.MustInvoke[*twitch.Client](di).RunRefreshLoop(appCtx)
 	go do.MustInvoke[*twitch_irc.Client](di).RunRefreshLoop(appCtx)
+	go do.MustInvoke[*memory.Service](di).RunPruneLoop(appCtx)
+	go do.MustInvoke[*streaminfo.Service](di).RunLoop(appCtx)
+	go do.MustInvoke[*approval.Service](di).RunLoop(appCtx)
 
 	go do.MustInvoke[*engine.Service](di).Run(appCtx)
 